@@ -6,40 +6,179 @@ package main
 //
 
 import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
-	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
-// WMStatsManager manages wmstats data
+// WMStatsManager manages wmstats data, its state is updated by refresh
+// goroutine and it should be accessed via Snapshot by other goroutines
 type WMStatsManager struct {
-	URI           string // wmstats URI (URL or file name)
-	Data          []byte // wmstats data
-	TTL           int64  // time-to-live of current cache snapshot
-	RenewInterval int64  // renew interval for cache
+	Label         string          // label of wmstats source
+	URI           string          // wmstats URI (URL or file name)
+	Data          []byte          // wmstats data
+	Updated       time.Time       // time of last successful update of wmstats data
+	Modified      time.Time       // modification time of local wmstats files, i.e. time of their data
+	Checked       time.Time       // time of last successful check of wmstats input
+	Error         error           // error of last update, the last good data is kept on failure
+	Duration      time.Duration   // duration of last refresh of wmstats data
+	Refreshes     uint64          // total number of refreshes of wmstats data
+	Failures      uint64          // total number of failed refreshes
+	Consecutive   uint64          // number of consecutive failed refreshes
+	Validators    FetchValidators // HTTP cache validators of last fetched data
+	TTL           int64           // time-to-live of current cache snapshot
+	RenewInterval int64           // renew interval for cache
 	CampaignMap   CampaignStatsMap
 	SiteMap       SiteStatsMap
 	CMSSWMap      CMSSWStatsMap
 	AgentMap      AgentStatsMap
+	mutex         sync.RWMutex
+}
+
+// Snapshot returns copy of wmstats manager state, the wmstats data is
+// shared with the copy since it is replaced rather than modified in place
+func (w *WMStatsManager) Snapshot() *WMStatsManager {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	return &WMStatsManager{
+		Label:         w.Label,
+		URI:           w.URI,
+		Data:          w.Data,
+		Updated:       w.Updated,
+		Modified:      w.Modified,
+		Checked:       w.Checked,
+		Error:         w.Error,
+		Duration:      w.Duration,
+		Refreshes:     w.Refreshes,
+		Failures:      w.Failures,
+		Consecutive:   w.Consecutive,
+		Validators:    w.Validators,
+		TTL:           w.TTL,
+		RenewInterval: w.RenewInterval,
+		CampaignMap:   w.CampaignMap,
+		SiteMap:       w.SiteMap,
+		CMSSWMap:      w.CMSSWMap,
+		AgentMap:      w.AgentMap,
+	}
+}
+
+// helper function to check if cache of wmstats data is expired
+func (w *WMStatsManager) expired() bool {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	return w.TTL < time.Now().Unix()
 }
 
 // helper function to update cache
 func (w *WMStatsManager) update() {
-	if w.TTL < time.Now().Unix() {
-		var data []byte
-		var err error
-		if _, err := os.Stat(w.URI); err == nil {
-			data, err = readFile(w.URI)
+	w.updateContext(context.Background())
+}
+
+// helper function to update cache, remote requests are canceled along with
+// given context. The data is fetched without holding the lock of manager.
+func (w *WMStatsManager) updateContext(ctx context.Context) {
+	if w.expired() {
+		ctx, span := startSpan(ctx, "refresh source",
+			attribute.String("wmstats.source", w.Label),
+			attribute.String("wmstats.uri", w.URI))
+		defer span.End()
+		time0 := time.Now()
+		err := w.read(ctx)
+		duration := time.Since(time0)
+		if err != nil && !errors.Is(err, ErrNotModified) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.SetAttributes(attribute.Bool("wmstats.not_modified", errors.Is(err, ErrNotModified)))
+
+		w.mutex.Lock()
+		notModified := errors.Is(err, ErrNotModified)
+		if notModified {
+			w.Checked = time.Now()
+			err = nil
+		}
+		w.Duration = duration
+		w.Refreshes++
+		if err != nil {
+			w.Failures++
+			w.Consecutive++
+		} else {
+			w.Consecutive = 0
+		}
+		w.Error = err
+		w.TTL = time.Now().Unix() + w.RenewInterval
+		updated, size := w.Updated, len(w.Data)
+		w.mutex.Unlock()
+
+		if notModified {
+			logInfo(ctx, "WMStats data is not modified", "source", w.Label, "uri", w.URI, "duration", duration)
+		} else if err != nil && updated.IsZero() {
+			logError(ctx, "unable to update WMStats cache", "source", w.Label, "uri", w.URI, "error", err)
+		} else if err != nil {
+			logError(ctx, "unable to update WMStats cache, keep last good snapshot", "source", w.Label, "uri", w.URI, "updated", updated, "error", err)
 		} else {
-			data, err = fetch(w.URI)
+			logInfo(ctx, "update WMStats cache", "source", w.Label, "uri", w.URI, "size", size, "duration", duration)
 		}
+	}
+}
+
+// helper function to read wmstats input, remote inputs are fetched
+// conditionally, i.e. ErrNotModified is returned if data is not changed.
+// The data is replaced only if it is valid JSON.
+func (w *WMStatsManager) read(ctx context.Context) error {
+	files, err := inputFiles(w.URI)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	var modified time.Time
+	w.mutex.RLock()
+	validators := w.Validators
+	w.mutex.RUnlock()
+	if files == nil {
+		data, validators, err = fetchWithRetries(ctx, w.URI, validators)
+	} else {
+		_, span := startSpan(ctx, "read", attribute.Int("wmstats.files", len(files)))
+		data, err = readInput(w.URI)
+		endSpan(span, err)
 		if err == nil {
-			w.Data = data
+			modified, err = modTime(files)
 		}
-		log.Println("update WMStats cache with", w.URI, err)
-		w.TTL = time.Now().Unix() + w.RenewInterval
 	}
+	if err != nil {
+		return err
+	}
+	_, span := startSpan(ctx, "validate", attribute.Int("wmstats.size", len(data)))
+	if !json.Valid(data) {
+		err := &DataError{URI: w.URI, Err: fmt.Errorf("invalid JSON: %s", bodySnippet(data))}
+		endSpan(span, err)
+		return err
+	}
+	span.End()
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.Data = data
+	w.Validators = validators
+	w.Modified = modified
+	w.Updated = time.Now()
+	w.Checked = w.Updated
+	return nil
 }
 
 // NewWMStatsManager method properly initialize WMStatsManager
@@ -51,13 +190,228 @@ func NewWMStatsManager(uri string, renew ...int64) *WMStatsManager {
 	return wmstats
 }
 
-// helper function to read data from a file
+// helper function to read data from a file, compressed files (gzip,
+// zstd or bzip2) are transparently decompressed
 func readFile(fname string) ([]byte, error) {
 	file, err := os.Open(fname)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer file.Close()
-	data, err := io.ReadAll(file)
-	return data, err
+	reader := bufio.NewReader(file)
+	magic, _ := reader.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read gzip file %s: %w", fname, err)
+		}
+		defer gz.Close()
+		return io.ReadAll(gz)
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read zstd file %s: %w", fname, err)
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return io.ReadAll(bzip2.NewReader(reader))
+	}
+	return io.ReadAll(reader)
+}
+
+// helper function to get modification time of given files, the oldest one
+// is returned since data of files is as fresh as its oldest file
+func modTime(files []string) (time.Time, error) {
+	var mtime time.Time
+	for _, fname := range files {
+		fi, err := os.Stat(fname)
+		if err != nil {
+			return mtime, err
+		}
+		if mtime.IsZero() || fi.ModTime().Before(mtime) {
+			mtime = fi.ModTime()
+		}
+	}
+	return mtime, nil
+}
+
+// InputExtensions defines file extensions of wmstats dumps we read from directories
+var InputExtensions = []string{".json", ".json.gz", ".json.zst", ".json.bz2", ".gz", ".zst", ".bz2"}
+
+// helper function to check if given string is a glob pattern
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// helper function to resolve wmstats input into list of files, the input
+// can be a file, a directory with wmstats dumps or a glob pattern. It
+// returns nil if input is not a local one, e.g. it is URL.
+func inputFiles(uri string) ([]string, error) {
+	if fi, err := os.Stat(uri); err == nil {
+		if !fi.IsDir() {
+			return []string{uri}, nil
+		}
+		entries, err := os.ReadDir(uri)
+		if err != nil {
+			return nil, err
+		}
+		var files []string
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			for _, ext := range InputExtensions {
+				if strings.HasSuffix(e.Name(), ext) {
+					files = append(files, filepath.Join(uri, e.Name()))
+					break
+				}
+			}
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no wmstats files found in %s", uri)
+		}
+		return files, nil
+	}
+	if isGlob(uri) && !strings.Contains(uri, "://") {
+		files, err := filepath.Glob(uri)
+		if err != nil {
+			return nil, err
+		}
+		var regular []string
+		for _, fname := range files {
+			if fi, err := os.Stat(fname); err == nil && fi.Mode().IsRegular() {
+				regular = append(regular, fname)
+			}
+		}
+		files = regular
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no wmstats files match %s", uri)
+		}
+		sort.Strings(files)
+		return files, nil
+	}
+	if !strings.Contains(uri, "://") {
+		// local file which is not (or no longer) available
+		_, err := os.Stat(uri)
+		return nil, err
+	}
+	return nil, nil
+}
+
+// helper function to merge wmstats records of given payloads into single
+// dataset. Payload can be either wmstats server response, i.e. {"result": [...]},
+// or single map of workflow records, e.g. per-agent dump. Records of the same
+// workflow, e.g. from dumps of different agents, are merged into single one.
+func mergeRecords(payloads map[string][]byte) ([]byte, error) {
+	var names []string
+	for name := range payloads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	records := []map[string]json.RawMessage{}
+	// index of record which holds given workflow
+	index := make(map[string]int)
+	add := func(rec map[string]json.RawMessage) error {
+		out := make(map[string]json.RawMessage)
+		for workflow, data := range rec {
+			if idx, ok := index[workflow]; ok {
+				merged, err := mergeAgentJobInfo(records[idx][workflow], data)
+				if err != nil {
+					return fmt.Errorf("unable to merge records of %s: %w", workflow, err)
+				}
+				records[idx][workflow] = merged
+				continue
+			}
+			index[workflow] = len(records)
+			out[workflow] = data
+		}
+		if len(out) > 0 || len(rec) == 0 {
+			records = append(records, out)
+		}
+		return nil
+	}
+	for _, name := range names {
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal(payloads[name], &doc); err != nil {
+			return nil, fmt.Errorf("unable to parse %s: %w", name, err)
+		}
+		recs := []map[string]json.RawMessage{doc}
+		if result, ok := doc["result"]; ok {
+			recs = nil
+			if err := json.Unmarshal(result, &recs); err != nil {
+				return nil, fmt.Errorf("unable to parse result of %s: %w", name, err)
+			}
+		}
+		for _, rec := range recs {
+			if err := add(rec); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return json.Marshal(map[string][]map[string]json.RawMessage{"result": records})
+}
+
+// helper function to merge AgentJobInfo of given workflow records, the
+// rest of attributes is taken from the first record
+func mergeAgentJobInfo(rec1, rec2 json.RawMessage) (json.RawMessage, error) {
+	var r1, r2 map[string]json.RawMessage
+	if err := json.Unmarshal(rec1, &r1); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(rec2, &r2); err != nil {
+		return nil, err
+	}
+	agents := make(map[string]json.RawMessage)
+	for _, r := range []map[string]json.RawMessage{r1, r2} {
+		if data, ok := r["AgentJobInfo"]; ok && string(data) != "null" {
+			var ainfo map[string]json.RawMessage
+			if err := json.Unmarshal(data, &ainfo); err != nil {
+				return nil, err
+			}
+			for agent, info := range ainfo {
+				agents[agent] = info
+			}
+		}
+	}
+	if len(agents) == 0 {
+		return rec1, nil
+	}
+	data, err := json.Marshal(agents)
+	if err != nil {
+		return nil, err
+	}
+	if r1 == nil {
+		r1 = make(map[string]json.RawMessage)
+	}
+	r1["AgentJobInfo"] = data
+	return json.Marshal(r1)
+}
+
+// helper function to read wmstats data from given input which can be URL,
+// file (possibly compressed), directory or glob pattern. Records of files
+// are always normalized into single wmstats dataset, i.e. {"result": [...]},
+// since a file may hold bare map of workflow records, e.g. per-agent dump.
+func readInput(uri string) ([]byte, error) {
+	files, err := inputFiles(uri)
+	if err != nil {
+		return nil, err
+	}
+	if files == nil {
+		return fetch(uri)
+	}
+	payloads := make(map[string][]byte)
+	for _, fname := range files {
+		data, err := readFile(fname)
+		if err != nil {
+			return nil, err
+		}
+		payloads[fname] = data
+	}
+	data, err := mergeRecords(payloads)
+	if err != nil {
+		return nil, &DataError{URI: uri, Err: err}
+	}
+	return data, nil
 }
@@ -0,0 +1,295 @@
+package main
+
+// cache_test module provides unit tests of wmstats inputs
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// bzip2 compressed {"result":[]} since bzip2 package does not provide compression
+var bzip2Data = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x44, 0xc6, 0xbf, 0x17,
+	0x00, 0x00, 0x05, 0x9b, 0x80, 0x10, 0x00, 0x00, 0x10, 0x00, 0x0a, 0x02, 0x04, 0x1e,
+	0x0a, 0x20, 0x00, 0x22, 0x00, 0x34, 0xd0, 0x40, 0xd0, 0x34, 0x01, 0x78, 0x90, 0x64,
+	0x69, 0xee, 0xf1, 0x77, 0x24, 0x53, 0x85, 0x09, 0x04, 0x4c, 0x6b, 0xf1, 0x70,
+}
+
+// helper function to write test file in given directory
+func writeTestFile(t *testing.T, dir, name string, data []byte) string {
+	fname := filepath.Join(dir, name)
+	if err := os.WriteFile(fname, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fname
+}
+
+// helper function to compress data with gzip
+func gzipData(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(data)
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// helper function to compress data with zstd
+func zstdData(t *testing.T, data []byte) []byte {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	return enc.EncodeAll(data, nil)
+}
+
+// TestReadFile tests detection of compression of wmstats files
+func TestReadFile(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte(`{"result":[]}`)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"plain.json", data},
+		// compression is detected by content rather than extension
+		{"gzip.json", gzipData(t, data)},
+		{"zstd.json.zst", zstdData(t, data)},
+		{"bzip2.bz2", bzip2Data},
+		{"short.json", []byte("{}")},
+	}
+	for _, tt := range tests {
+		fname := writeTestFile(t, dir, tt.name, tt.data)
+		out, err := readFile(fname)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		expected := data
+		if tt.name == "short.json" {
+			expected = tt.data
+		}
+		if !bytes.Equal(out, expected) {
+			t.Errorf("%s: got %q, expected %q", tt.name, out, expected)
+		}
+	}
+
+	// corrupted gzip file and missing file
+	fname := writeTestFile(t, dir, "bad.gz", []byte{0x1f, 0x8b, 0x00})
+	if _, err := readFile(fname); err == nil {
+		t.Errorf("no error for corrupted gzip file")
+	}
+	if _, err := readFile(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("no error for missing file")
+	}
+}
+
+// TestInputFiles tests resolution of wmstats inputs into list of files
+func TestInputFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.json", "a.json.gz", "c.zst", "notes.txt"} {
+		writeTestFile(t, dir, name, []byte("{}"))
+	}
+	os.Mkdir(filepath.Join(dir, "sub.json"), 0755)
+	empty := t.TempDir()
+	tests := []struct {
+		uri   string
+		files []string
+		fail  bool
+	}{
+		{filepath.Join(dir, "b.json"), []string{filepath.Join(dir, "b.json")}, false},
+		{dir, []string{filepath.Join(dir, "a.json.gz"), filepath.Join(dir, "b.json"), filepath.Join(dir, "c.zst")}, false},
+		{filepath.Join(dir, "*.json*"), []string{filepath.Join(dir, "a.json.gz"), filepath.Join(dir, "b.json")}, false},
+		// directories are not wmstats files
+		{filepath.Join(dir, "sub*"), nil, true},
+		{filepath.Join(dir, "*.xml"), nil, true},
+		{filepath.Join(dir, "missing.json"), nil, true},
+		{empty, nil, true},
+		{"https://cmsweb.cern.ch/wmstatsserver/data/requestcache", nil, false},
+	}
+	for _, tt := range tests {
+		files, err := inputFiles(tt.uri)
+		if tt.fail != (err != nil) {
+			t.Errorf("%s: unexpected error %v", tt.uri, err)
+		}
+		if !reflect.DeepEqual(files, tt.files) {
+			t.Errorf("%s: got %v, expected %v", tt.uri, files, tt.files)
+		}
+	}
+}
+
+// TestMergeRecords tests merge of wmstats payloads
+func TestMergeRecords(t *testing.T) {
+	tests := []struct {
+		name     string
+		payloads map[string][]byte
+		expected string
+		fail     bool
+	}{
+		{"server response", map[string][]byte{"a": []byte(`{"result":[{"wf1":{}},{"wf2":{}}]}`)},
+			`{"result":[{"wf1":{}},{"wf2":{}}]}`, false},
+		{"per-agent dump", map[string][]byte{"a": []byte(`{"wf1":{},"wf2":{}}`)},
+			`{"result":[{"wf1":{},"wf2":{}}]}`, false},
+		// records are merged in order of payload names
+		{"mixed payloads", map[string][]byte{
+			"b": []byte(`{"wf3":{}}`),
+			"a": []byte(`{"result":[{"wf1":{}}]}`),
+		}, `{"result":[{"wf1":{}},{"wf3":{}}]}`, false},
+		// job info of agents is merged into the first record of workflow
+		{"same workflow", map[string][]byte{
+			"a": []byte(`{"wf1":{"RequestName":"wf1","AgentJobInfo":{"agent1":{"Status":{"success":10}}}}}`),
+			"b": []byte(`{"wf1":{"RequestName":"wf1","AgentJobInfo":{"agent2":{"Status":{"success":5}}}},"wf2":{}}`),
+		}, `{"result":[{"wf1":{"AgentJobInfo":{"agent1":{"Status":{"success":10}},"agent2":{"Status":{"success":5}}},"RequestName":"wf1"}},{"wf2":{}}]}`, false},
+		{"same workflow without job info", map[string][]byte{
+			"a": []byte(`{"wf1":{"RequestName":"wf1"}}`),
+			"b": []byte(`{"result":[{"wf1":{"RequestName":"wf1"}}]}`),
+		}, `{"result":[{"wf1":{"RequestName":"wf1"}}]}`, false},
+		{"invalid record of same workflow", map[string][]byte{
+			"a": []byte(`{"wf1":{}}`),
+			"b": []byte(`{"wf1":[]}`),
+		}, "", true},
+		{"empty result", map[string][]byte{"a": []byte(`{"result":[]}`)}, `{"result":[]}`, false},
+		{"invalid JSON", map[string][]byte{"a": []byte(`{"result":`)}, "", true},
+		{"invalid result", map[string][]byte{"a": []byte(`{"result":{}}`)}, "", true},
+	}
+	for _, tt := range tests {
+		data, err := mergeRecords(tt.payloads)
+		if tt.fail != (err != nil) {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if !tt.fail && string(data) != tt.expected {
+			t.Errorf("%s: got %s, expected %s", tt.name, data, tt.expected)
+		}
+	}
+}
+
+// TestReadInput tests that records of wmstats inputs are normalized
+func TestReadInput(t *testing.T) {
+	record := func(name string) string {
+		return `"` + name + `":{"RequestName":"` + name + `","Campaign":"Run2022A","RequestStatus":"running-open"}`
+	}
+	single := t.TempDir()
+	writeTestFile(t, single, "agent1.json.gz", gzipData(t, []byte("{"+record("wf1")+","+record("wf2")+"}")))
+	multi := t.TempDir()
+	writeTestFile(t, multi, "agent1.json", []byte("{"+record("wf1")+"}"))
+	writeTestFile(t, multi, "agent2.json", []byte(`{"result":[{`+record("wf2")+`}]}`))
+	response := t.TempDir()
+	fname := writeTestFile(t, response, "requestcache.json", []byte(`{"result":[{`+record("wf1")+`}]}`))
+	bad := writeTestFile(t, t.TempDir(), "bad.json", []byte("{bla"))
+
+	tests := []struct {
+		uri       string
+		workflows int
+	}{
+		{single, 2},
+		{multi, 2},
+		{fname, 1},
+		{filepath.Join(multi, "*.json"), 2},
+	}
+	for _, tt := range tests {
+		data, err := readInput(tt.uri)
+		if err != nil {
+			t.Errorf("%s: %v", tt.uri, err)
+			continue
+		}
+		wmgr := &WMStatsManager{URI: tt.uri, Data: data}
+		info, err := wmstats(context.Background(), wmgr, nil, 0)
+		if err != nil {
+			t.Errorf("%s: %v", tt.uri, err)
+			continue
+		}
+		if len(info.Workflows) != tt.workflows {
+			t.Errorf("%s: %d workflows, expected %d", tt.uri, len(info.Workflows), tt.workflows)
+		}
+	}
+
+	// malformed input is reported as data error
+	var derr *DataError
+	if _, err := readInput(bad); !errors.As(err, &derr) {
+		t.Errorf("wrong error of malformed input %v", err)
+	}
+	data, _ := readInput(fname)
+	if !json.Valid(data) {
+		t.Errorf("invalid JSON %s", data)
+	}
+}
+
+// TestReadInputAgentDumps tests that statistics of workflow which is
+// present in dumps of several agents are accumulated
+func TestReadInputAgentDumps(t *testing.T) {
+	record := func(agent string, success int) string {
+		return fmt.Sprintf(`{"wf1":{"RequestName":"wf1","Campaign":"Run2022A","CMSSWVersion":"CMSSW_12_4_0",
+			"RequestStatus":"running-open","AgentJobInfo":{"%s":{"Workflow":"wf1","Status":{"success":%d},
+			"Sites":{"T1_US_FNAL":{"success":%d}}}}}}`, agent, success, success)
+	}
+	dir := t.TempDir()
+	writeTestFile(t, dir, "agent1.json", []byte(record("agent1", 10)))
+	writeTestFile(t, dir, "agent2.json", []byte(record("agent2", 5)))
+	data, err := readInput(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := wmstats(context.Background(), &WMStatsManager{URI: dir, Data: data}, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	winfo := info.Workflows["wf1"]
+	if winfo.Status.Success != 15 || winfo.SiteJobs["T1_US_FNAL"].Success != 15 {
+		t.Errorf("wrong status of workflow %+v", winfo)
+	}
+	if agents := len(winfo.Agents); agents != 2 {
+		t.Errorf("%d agents of workflow, expected 2", agents)
+	}
+	if rows := len(info.Table("workflows").Rows); rows != 1 {
+		t.Errorf("%d rows of workflows table, expected 1", rows)
+	}
+	if requests := info.CMSSWStatsMap["CMSSW_12_4_0"].Requests; requests != 1 {
+		t.Errorf("%d requests of cmssw release, expected 1", requests)
+	}
+	if requests := info.CampaignStatsMap["Run2022A"].Requests; requests != 1 {
+		t.Errorf("%d requests of campaign, expected 1", requests)
+	}
+	if stats := info.SiteStatsMap["T1_US_FNAL"]; stats.Requests != 1 || stats.SuccessJobs != 15 {
+		t.Errorf("wrong site stats %+v", stats)
+	}
+}
+
+// TestWMStatsManagerFreshness tests that freshness of local wmstats files
+// is taken from their modification time
+func TestWMStatsManagerFreshness(t *testing.T) {
+	dir := t.TempDir()
+	mtime := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+	for i, name := range []string{"agent1.json", "agent2.json"} {
+		fname := writeTestFile(t, dir, name, []byte(`{"result":[]}`))
+		// freshness of directory is modification time of its oldest file
+		if err := os.Chtimes(fname, mtime, mtime.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wmgr := NewWMStatsManager(dir)
+	wmgr.update()
+	f := wmgr.Freshness()
+	if f.Failed || !f.Updated.Equal(mtime) {
+		t.Errorf("wrong update time %v of files modified at %v", f.Updated, mtime)
+	}
+	if f.Age < 7200 || !f.Stale {
+		t.Errorf("wrong age %d or staleness %v of files", f.Age, f.Stale)
+	}
+}
@@ -6,52 +6,320 @@ package main
 //
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"net/url"
+	"os"
 	"strings"
 )
 
-// cli provides CLI interface to wmstats
-func cli(wmstatsFile string, filters WMStatsFilters, stats string, verbose int) {
-	wmgr := NewWMStatsManager(wmstatsFile)
+// CliOptions represents common options of CLI commands
+type CliOptions struct {
+	File    string // wmstats input, either file, directory, glob pattern or URL
+	Server  string // URL of running wmstats server to query instead of wmstats input
+	Sources string // comma separated list of label=URI wmstats sources
+	Source  string // wmstats source (namespace) to use
+	Filters string // comma separated wmstats filters
+	Format  string // output format
+	Sort    string // sort column
+	Order   string // sort order
+	Columns string // comma separated list of columns
+	Limit   int    // max number of rows
+	Offset  int    // number of rows to skip
+	Token   string // access token or file name with the token
+	Timeout int    // HTTP timeout in seconds
+	Retries int    // number of retries of failed HTTP requests
+	Stale   int64  // age (in seconds) after which wmstats data is considered stale
+	Push    string // URL of Prometheus Pushgateway to push wmstats metrics to
+	Remote  string // URL of Prometheus remote-write endpoint to push wmstats metrics to
+	Job     string // job name of pushed wmstats metrics
+	Verbose int    // verbosity level
+	NoColor bool   // disable ANSI colors
+}
+
+// Register registers CLI options in given flag set
+func (o *CliOptions) Register(fs *flag.FlagSet) {
+	fs.StringVar(&o.File, "file", "", "wmstats input: file (optionally compressed), directory, glob pattern or URL")
+	fs.StringVar(&o.File, "wmstatsFile", "", "wmstats input (alias to -file)")
+	fs.StringVar(&o.Sources, "sources", "", "comma separated list of label=URI wmstats sources, e.g. prod=URL1,testbed=URL2")
+	fs.StringVar(&o.Source, "source", "", fmt.Sprintf("wmstats source label to use, by default all sources are merged (%s)", MergedSource))
+	fs.StringVar(&o.Server, "server", "", "URL of running wmstats server to query, e.g. https://cmsweb.cern.ch/wmstats")
+	fs.StringVar(&o.Filters, "filters", "", "comma separated wmstats filters, e.g. campaign=RunII,site=T1")
+	fs.StringVar(&o.Format, "format", "table", fmt.Sprintf("output format: %s", strings.Join(OutputFormats, ", ")))
+	fs.StringVar(&o.Sort, "sort", "", "sort table by given column, e.g. requests")
+	fs.StringVar(&o.Order, "order", "asc", "sort order: asc or desc")
+	fs.IntVar(&o.Limit, "limit", 0, "limit number of rows, 0 means no limit")
+	fs.IntVar(&o.Offset, "offset", 0, "number of rows to skip")
+	fs.StringVar(&o.Columns, "columns", "", "comma separated list of columns to display")
+	fs.StringVar(&o.Token, "token", "", "access token or file name with the token")
+	fs.IntVar(&o.Timeout, "timeout", 0, "HTTP timeout in seconds")
+	fs.IntVar(&o.Retries, "retries", FetchRetries, "number of retries of failed HTTP requests")
+	fs.Int64Var(&o.Stale, "stale", StaleThreshold, "age (in seconds) after which wmstats data is reported as stale, 0 disables the check")
+	fs.StringVar(&o.Push, "pushgateway", "", "URL of Prometheus Pushgateway to push site, campaign, cmssw and agent metrics to")
+	fs.StringVar(&o.Remote, "remote-write", "", "URL of Prometheus remote-write endpoint to push site, campaign, cmssw and agent metrics to")
+	fs.StringVar(&o.Job, "push-job", PushJob, "job name of pushed metrics")
+	fs.IntVar(&o.Verbose, "verbose", 0, "verbose level")
+	fs.BoolVar(&o.NoColor, "no-color", false, "disable ANSI colors in table output")
+}
+
+// TableOptions returns table options of CLI options
+func (o *CliOptions) TableOptions() TableOptions {
+	return NewTableOptions(o.Sort, o.Order, o.Limit, o.Offset, o.Columns)
+}
+
+// Setup validates output format and setups global fetch parameters
+func (o *CliOptions) Setup() error {
+	valid := false
+	for _, f := range OutputFormats {
+		if strings.ToLower(o.Format) == f {
+			valid = true
+		}
+	}
+	if !valid {
+		return fmt.Errorf("unsupported format '%s', supported formats: %s", o.Format, strings.Join(OutputFormats, ", "))
+	}
+	if o.Token != "" {
+		Token = o.Token
+	}
+	if o.Timeout > 0 {
+		TIMEOUT = o.Timeout
+	}
+	if o.Retries >= 0 {
+		FetchRetries = o.Retries
+	}
+	if o.Stale >= 0 {
+		StaleThreshold = o.Stale
+	}
+	if o.NoColor {
+		NoColor = true
+	}
+	if o.Push != "" || o.Remote != "" {
+		if o.Server != "" {
+			return fmt.Errorf("metrics can't be pushed when querying wmstats server")
+		}
+		PushGateway = o.Push
+		RemoteWrite = o.Remote
+		if o.Job != "" {
+			PushJob = o.Job
+		}
+	}
+	return nil
+}
+
+// helper function to get source label of wmstats info of CLI options
+func (o *CliOptions) sourceLabel() string {
+	if o.Source != "" {
+		return o.Source
+	}
+	if o.Sources != "" {
+		return MergedSource
+	}
+	return "default"
+}
+
+// helper function to push metrics of wmstats info of CLI options
+func (o *CliOptions) push(info *WMStatsInfo) error {
+	return pushMetrics(map[string]*WMStatsInfo{o.sourceLabel(): info})
+}
+
+// helper function to load wmstats info from given input along with
+// freshness of its data
+func loadWMStatsInfo(uri string, filters WMStatsFilters, verbose int) (*WMStatsInfo, Freshness, error) {
+	if uri == "" {
+		return nil, Freshness{}, fmt.Errorf("wmstats input is not provided, please use -file option")
+	}
+	wmgr := NewWMStatsManager(uri)
 	wmgr.update()
-	_wmstatsInfo := wmstats(wmgr, filters, verbose)
-	var headers []string
-	var values [][]string
-	var paddings []int
-	if stats == "agent" {
-		headers, values, paddings = _wmstatsInfo.AgentStatsMap.CliTable()
-	} else if stats == "site" {
-		headers, values, paddings = _wmstatsInfo.SiteStatsMap.CliTable()
-	} else if stats == "cmssw" {
-		headers, values, paddings = _wmstatsInfo.CMSSWStatsMap.CliTable()
-	} else if stats == "campaign" {
-		headers, values, paddings = _wmstatsInfo.CampaignStatsMap.CliTable()
-	} else {
-		headers, values, paddings = _wmstatsInfo.CampaignStatsMap.CliTable()
-	}
-
-	// print headers
-	var rowValues []string
-	for k, v := range headers {
-		if len(v) <= paddings[k] {
-			vals := make([]string, paddings[k]+1-len(v))
-			rowValues = append(rowValues, v+strings.Join(vals, " "))
+	if wmgr.Error != nil {
+		return nil, Freshness{}, wmgr.Error
+	}
+	fresh := wmgr.Freshness()
+	fresh.Source = uri
+	info, err := wmstats(context.Background(), wmgr, filters, verbose)
+	if errors.Is(err, ErrNoData) {
+		return nil, fresh, fmt.Errorf("no wmstats data found in %s", uri)
+	}
+	if info != nil {
+		fresh.Workflows = len(info.Workflows)
+	}
+	return info, fresh, err
+}
+
+// helper function to load wmstats info of CLI options along with freshness
+// of its data, the input is either single wmstats input or set of labeled
+// sources
+func (o *CliOptions) loadInfo() (*WMStatsInfo, Freshness, error) {
+	filters := wmstatsFilters(o.Filters)
+	if o.Sources == "" {
+		if o.Source != "" {
+			return nil, Freshness{}, fmt.Errorf("wmstats source '%s' is provided without -sources option", o.Source)
+		}
+		return loadWMStatsInfo(o.File, filters, o.Verbose)
+	}
+	if o.File != "" {
+		return nil, Freshness{}, fmt.Errorf("wmstats input and sources can't be used together")
+	}
+	sources, err := parseSources(o.Sources)
+	if err != nil {
+		return nil, Freshness{}, err
+	}
+	wsrc, err := NewWMStatsSources(sources, true)
+	if err != nil {
+		return nil, Freshness{}, err
+	}
+	wsrc.update(context.Background())
+	for _, status := range wsrc.Status() {
+		if !status.Healthy {
+			fmt.Fprintf(os.Stderr, "WARNING: source %s (%s) is unhealthy: %s\n", status.Label, status.URI, status.Error)
+		}
+	}
+	mgr, err := wsrc.Manager(o.Source)
+	if err != nil {
+		return nil, Freshness{}, err
+	}
+	fresh, err := wsrc.Freshness(o.Source)
+	if err != nil {
+		return nil, fresh, err
+	}
+	info, err := wmstats(context.Background(), mgr, filters, o.Verbose)
+	if errors.Is(err, ErrNoData) {
+		return nil, fresh, fmt.Errorf("no wmstats data found in %s source", mgr.Label)
+	}
+	if info != nil {
+		fresh.Workflows = len(info.Workflows)
+	}
+	return info, fresh, err
+}
+
+// cli provides CLI interface to wmstats
+func cli(opts CliOptions, stats string) error {
+	if err := opts.Setup(); err != nil {
+		return err
+	}
+	if opts.Server != "" {
+		params := url.Values{}
+		params.Set("stats", stats)
+		t, err := serverTable(opts, "/", params, emptyTable(stats))
+		if err != nil {
+			return err
+		}
+		return writeCliTable(t, opts.Format)
+	}
+	info, fresh, err := opts.loadInfo()
+	if err != nil {
+		return err
+	}
+	t := info.Table(stats).Apply(opts.TableOptions())
+	t.Freshness = &fresh
+	if err := writeCliTable(t, opts.Format); err != nil {
+		return err
+	}
+	return opts.push(info)
+}
+
+// helper function to write table to stdout and freshness of its data to stderr
+func writeCliTable(t *Table, format string) error {
+	if err := WriteTable(os.Stdout, t, format); err != nil {
+		return err
+	}
+	writeFreshness(os.Stderr, t.Freshness)
+	return nil
+}
+
+// cliWorkflows provides CLI interface to list workflows associated with
+// given attribute (campaign, site, cmssw, agent or prepid), if attribute
+// is not provided all workflows are listed
+func cliWorkflows(opts CliOptions, key, value string) error {
+	if err := opts.Setup(); err != nil {
+		return err
+	}
+	if opts.Server != "" {
+		params := url.Values{}
+		path := "/"
+		if key != "" {
+			path = "/workflows"
+			params.Set(key, value)
 		} else {
-			rowValues = append(rowValues, v)
+			params.Set("stats", "workflows")
+		}
+		t, err := serverTable(opts, path, params, workflowTable(nil))
+		if err != nil {
+			return err
+		}
+		return writeCliTable(t, opts.Format)
+	}
+	info, fresh, err := opts.loadInfo()
+	if err != nil {
+		return err
+	}
+	t := info.Table("workflows")
+	if key != "" {
+		workflows, ok := info.WorkflowsBy(key, value)
+		if !ok {
+			return fmt.Errorf("no workflows found for %s=%s", key, value)
+		}
+		t = workflowTable(workflows)
+	}
+	t = t.Apply(opts.TableOptions())
+	t.Freshness = &fresh
+	return writeCliTable(t, opts.Format)
+}
+
+// helper function to get empty table of given stats with its column definitions
+func emptyTable(stats string) *Table {
+	var info WMStatsInfo
+	return info.Table(stats)
+}
+
+// serverTable queries wmstats server JSON API and returns table. The table
+// options are applied by the server, while column titles are taken from
+// given table definition.
+func serverTable(opts CliOptions, path string, params url.Values, def *Table) (*Table, error) {
+	for k, v := range opts.TableOptions().Values() {
+		params[k] = v
+	}
+	if opts.Filters != "" {
+		params.Set("filters", opts.Filters)
+	}
+	if opts.Source != "" {
+		params.Set("source", opts.Source)
+	}
+	params.Set("format", "json")
+	rurl := fmt.Sprintf("%s%s?%s", strings.TrimSuffix(opts.Server, "/"), path, params.Encode())
+	data, err := fetch(rurl)
+	if err != nil {
+		return nil, err
+	}
+	var rec struct {
+		Name      string                   `json:"name"`
+		Columns   []string                 `json:"columns"`
+		Total     int                      `json:"total"`
+		Offset    int                      `json:"offset"`
+		Limit     int                      `json:"limit"`
+		Rows      []map[string]interface{} `json:"rows"`
+		Freshness *Freshness               `json:"freshness"`
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("unable to parse response from %s: %w", rurl, err)
+	}
+	t := &Table{Name: rec.Name, Total: rec.Total, Freshness: rec.Freshness}
+	t.Options = NewTableOptions(opts.Sort, opts.Order, rec.Limit, rec.Offset, strings.Join(rec.Columns, ","))
+	for _, name := range rec.Columns {
+		col := Column{Name: name, Title: name}
+		if idx := def.columnIndex(name); idx >= 0 {
+			col = def.Columns[idx]
 		}
+		t.Columns = append(t.Columns, col)
 	}
-	fmt.Println(strings.Join(rowValues, " "))
-	// print values
-	for _, vals := range values {
-		var rowValues []string
-		for k, v := range vals {
-			if len(v) <= paddings[k] {
-				vals := make([]string, paddings[k]+1-len(v))
-				rowValues = append(rowValues, v+strings.Join(vals, " "))
-			} else {
-				rowValues = append(rowValues, v)
-			}
+	for _, r := range rec.Rows {
+		var row TableRow
+		for _, name := range rec.Columns {
+			row = append(row, r[name])
 		}
-		fmt.Println(strings.Join(rowValues, " "))
+		t.Rows = append(t.Rows, row)
 	}
+	return t, nil
 }
@@ -0,0 +1,401 @@
+package main
+
+// wmstats commands module
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	limiter "github.com/ulule/limiter/v3"
+)
+
+// Command represents wmstats sub-command
+type Command struct {
+	Name        string                    // command name
+	Args        string                    // command arguments description
+	Description string                    // short command description
+	Run         func(args []string) error // command implementation
+}
+
+// UsageError represents error of command usage
+type UsageError struct {
+	Message string
+}
+
+// Error implements error interface
+func (e *UsageError) Error() string {
+	return e.Message
+}
+
+// Commands defines list of wmstats sub-commands
+var Commands []Command
+
+func init() {
+	Commands = []Command{
+		{Name: "serve", Args: "[-config config.json]", Description: "start wmstats server", Run: serveCommand},
+		{Name: "summary", Args: "[options] [file|URL]", Description: "show summary table of campaign, site, cmssw, agent or workflows", Run: summaryCommand},
+		{Name: "workflows", Args: "[options] [file|URL]", Description: "list workflows, optionally associated with given attribute", Run: workflowsCommand},
+		{Name: "tui", Args: "[options] [file|URL]", Description: "browse wmstats data in interactive terminal UI", Run: tuiCommand},
+		{Name: "diff", Args: "[options] old.json new.json", Description: "compare two wmstats snapshots", Run: diffCommand},
+		{Name: "fetch", Args: "[options] URL", Description: "fetch wmstats data and store it locally", Run: fetchCommand},
+		{Name: "validate-config", Args: "[-config config.json]", Description: "validate server configuration", Run: validateConfigCommand},
+		{Name: "version", Description: "show version", Run: func(args []string) error {
+			fmt.Println("wmstats version:", info())
+			return nil
+		}},
+	}
+}
+
+// helper function to find command by its name
+func findCommand(name string) *Command {
+	for i := range Commands {
+		if Commands[i].Name == name {
+			return &Commands[i]
+		}
+	}
+	return nil
+}
+
+// helper function to print usage of all commands
+func commandsUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: wmstats <command> [options]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, cmd := range Commands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", cmd.Name, cmd.Description)
+	}
+	fmt.Fprintln(os.Stderr, "\nUse \"wmstats <command> -help\" for more information about a command.")
+	fmt.Fprintln(os.Stderr, "Legacy flags, e.g. wmstats -wmstatsFile file.json -display site, are still supported.")
+}
+
+// helper function to create flag set of given command
+func commandFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		if cmd := findCommand(name); cmd != nil {
+			fmt.Fprintf(os.Stderr, "Usage: wmstats %s %s\n%s\n\nOptions:\n", cmd.Name, cmd.Args, cmd.Description)
+		}
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// helper function to parse command flags, it returns usage error if
+// flags can't be parsed
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return &UsageError{Message: err.Error()}
+	}
+	return nil
+}
+
+// runCommand runs given command and returns process exit code
+func runCommand(name string, args []string) int {
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		if len(args) > 0 {
+			if cmd := findCommand(args[0]); cmd != nil {
+				return runCommand(cmd.Name, []string{"-help"})
+			}
+		}
+		commandsUsage()
+		return 0
+	}
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "ERROR: unknown command '%s'\n\n", name)
+		commandsUsage()
+		return 2
+	}
+	err := cmd.Run(args)
+	if err == nil {
+		return 0
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	fmt.Fprintln(os.Stderr, "ERROR:", err)
+	var uerr *UsageError
+	if errors.As(err, &uerr) {
+		return 2
+	}
+	return 1
+}
+
+// serve command starts wmstats server
+func serveCommand(args []string) error {
+	fs := commandFlags("serve")
+	var config string
+	fs.StringVar(&config, "config", "", "config file")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	Server(config)
+	return nil
+}
+
+// helper function to use first positional argument as wmstats input
+func inputArg(fs *flag.FlagSet, opts *CliOptions) error {
+	if fs.NArg() > 1 {
+		return &UsageError{Message: fmt.Sprintf("too many arguments: %v", fs.Args())}
+	}
+	if fs.NArg() == 1 {
+		if opts.File != "" {
+			return &UsageError{Message: "wmstats input is provided both as -file option and argument"}
+		}
+		opts.File = fs.Arg(0)
+	}
+	return nil
+}
+
+// summary command shows summary table of wmstats data
+func summaryCommand(args []string) error {
+	fs := commandFlags("summary")
+	var opts CliOptions
+	opts.Register(fs)
+	var display string
+	fs.StringVar(&display, "display", "campaign", "display given attribute: campaign, site, cmssw, agent or workflows")
+	var watch time.Duration
+	fs.DurationVar(&watch, "watch", 0, "re-read wmstats input on given interval and redraw the table, e.g. 1m")
+	var top int
+	fs.IntVar(&top, "top", 0, "show top N rows (alias to -limit)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := inputArg(fs, &opts); err != nil {
+		return err
+	}
+	switch display {
+	case "campaign", "site", "cmssw", "agent", "workflows":
+	default:
+		return &UsageError{Message: fmt.Sprintf("unsupported display '%s'", display)}
+	}
+	if top > 0 {
+		opts.Limit = top
+	}
+	if watch > 0 {
+		return cliWatch(opts, display, watch)
+	}
+	return cli(opts, display)
+}
+
+// workflows command lists workflows
+func workflowsCommand(args []string) error {
+	fs := commandFlags("workflows")
+	var opts CliOptions
+	opts.Register(fs)
+	keys := []string{"campaign", "site", "cmssw", "agent", "prepid"}
+	values := make(map[string]*string)
+	for _, key := range keys {
+		values[key] = fs.String(key, "", fmt.Sprintf("show workflows associated with given %s", key))
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := inputArg(fs, &opts); err != nil {
+		return err
+	}
+	var key, value string
+	for _, k := range keys {
+		if v := *values[k]; v != "" {
+			if key != "" {
+				return &UsageError{Message: fmt.Sprintf("only one of -%s options can be used", strings.Join(keys, ", -"))}
+			}
+			key, value = k, v
+		}
+	}
+	return cliWorkflows(opts, key, value)
+}
+
+// tui command starts interactive terminal UI
+func tuiCommand(args []string) error {
+	fs := commandFlags("tui")
+	opts := CliOptions{Format: "table", Retries: FetchRetries, Stale: StaleThreshold}
+	fs.StringVar(&opts.File, "file", "", "wmstats input: file (optionally compressed), directory, glob pattern or URL")
+	fs.StringVar(&opts.Filters, "filters", "", "comma separated wmstats filters, e.g. campaign=RunII,site=T1")
+	fs.StringVar(&opts.Sources, "sources", "", "comma separated list of label=URI wmstats sources")
+	fs.StringVar(&opts.Source, "source", "", "wmstats source label to use, by default all sources are merged")
+	fs.StringVar(&opts.Token, "token", "", "access token or file name with the token")
+	fs.IntVar(&opts.Timeout, "timeout", 0, "HTTP timeout in seconds")
+	fs.IntVar(&opts.Retries, "retries", FetchRetries, "number of retries of failed HTTP requests")
+	fs.Int64Var(&opts.Stale, "stale", StaleThreshold, "age (in seconds) after which wmstats data is reported as stale, 0 disables the check")
+	fs.IntVar(&opts.Verbose, "verbose", 0, "verbose level")
+	fs.BoolVar(&opts.NoColor, "no-color", false, "disable ANSI colors")
+	var display string
+	fs.StringVar(&display, "display", "campaign", "initial view: campaign, site, cmssw or agent")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := inputArg(fs, &opts); err != nil {
+		return err
+	}
+	switch display {
+	case "campaign", "site", "cmssw", "agent":
+	default:
+		return &UsageError{Message: fmt.Sprintf("unsupported display '%s'", display)}
+	}
+	return cliTUI(opts, display)
+}
+
+// diff command compares two wmstats snapshots
+func diffCommand(args []string) error {
+	fs := commandFlags("diff")
+	var opts CliOptions
+	opts.Register(fs)
+	var dopts DiffOptions
+	fs.Float64Var(&dopts.Threshold, "threshold", 0, "min absolute change of counters (requests, running, pending, cooloff, failed jobs of agents and cmssw) to report")
+	fs.Float64Var(&dopts.RateThreshold, "rate-threshold", 0, "min absolute change of failure rate and progress (in percents) to report")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return &UsageError{Message: "diff requires two wmstats inputs: old and new"}
+	}
+	return cliDiff(opts, fs.Arg(0), fs.Arg(1), dopts)
+}
+
+// fetch command fetches wmstats data and writes it to a file or stdout
+func fetchCommand(args []string) error {
+	fs := commandFlags("fetch")
+	var opts CliOptions
+	fs.StringVar(&opts.File, "url", "", "wmstats URL, e.g. https://cmsweb.cern.ch/wmstatsserver/data/requestcache")
+	fs.StringVar(&opts.Token, "token", "", "access token or file name with the token")
+	fs.IntVar(&opts.Timeout, "timeout", 0, "HTTP timeout in seconds")
+	var output string
+	fs.StringVar(&output, "o", "", "output file name, by default data is written to stdout")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := inputArg(fs, &opts); err != nil {
+		return err
+	}
+	if opts.File == "" {
+		return &UsageError{Message: "wmstats URL is not provided"}
+	}
+	if opts.Token != "" {
+		Token = opts.Token
+	}
+	if opts.Timeout > 0 {
+		TIMEOUT = opts.Timeout
+	}
+	data, err := fetch(opts.File)
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(output, data, 0644)
+}
+
+// validate-config command validates server configuration
+func validateConfigCommand(args []string) error {
+	fs := commandFlags("validate-config")
+	var config string
+	fs.StringVar(&config, "config", "", "config file")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if config == "" && fs.NArg() == 1 {
+		config = fs.Arg(0)
+	}
+	if config == "" {
+		return &UsageError{Message: "config file is not provided"}
+	}
+	if err := ParseConfig(config); err != nil {
+		return err
+	}
+	if errs := validateConfig(); len(errs) > 0 {
+		for _, e := range errs {
+			fmt.Fprintln(os.Stderr, "  -", e)
+		}
+		return fmt.Errorf("configuration %s has %d error(s)", config, len(errs))
+	}
+	fmt.Println("configuration", config, "is valid")
+	return nil
+}
+
+// helper function to validate configuration, it returns list of errors
+func validateConfig() []error {
+	var errs []error
+	if Config.Port <= 0 || Config.Port > 65535 {
+		errs = append(errs, fmt.Errorf("invalid port %d", Config.Port))
+	}
+	sources := Config.WMStatsSources()
+	if len(sources) == 0 {
+		errs = append(errs, fmt.Errorf("neither access_uri nor sources are set"))
+	} else if _, err := NewWMStatsSources(sources, Config.MergeSources); err != nil {
+		errs = append(errs, err)
+	}
+	for _, src := range sources {
+		if files, err := inputFiles(src.URI); err != nil {
+			errs = append(errs, fmt.Errorf("source %s '%s': %v", src.Label, src.URI, err))
+		} else if files == nil {
+			if u, err := url.Parse(src.URI); err != nil || u.Scheme == "" || u.Host == "" {
+				errs = append(errs, fmt.Errorf("source %s '%s' is neither existing file, directory, glob pattern nor valid URL", src.Label, src.URI))
+			}
+		}
+	}
+	if _, err := limiter.NewRateFromFormatted(Config.LimiterPeriod); err != nil {
+		errs = append(errs, fmt.Errorf("invalid limiter_rate '%s': %v", Config.LimiterPeriod, err))
+	}
+	if Config.LogFormat != "" && Config.LogFormat != "text" && Config.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("invalid log_format '%s', supported formats: text, json", Config.LogFormat))
+	}
+	if Config.LogLevel != "" {
+		if _, err := parseLogLevel(Config.LogLevel); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for key, rurl := range map[string]string{"push_gateway": Config.PushGateway, "remote_write": Config.RemoteWrite, "otlp_endpoint": Config.OTLPEndpoint} {
+		if rurl == "" {
+			continue
+		}
+		if u, err := url.Parse(rurl); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s '%s' is not valid URL", key, rurl))
+		}
+	}
+	if Config.StaticDir != "" && !isDir(Config.StaticDir) {
+		errs = append(errs, fmt.Errorf("staticdir '%s' does not exist", Config.StaticDir))
+	}
+	if Config.Templates != "" && !isDir(Config.Templates) {
+		errs = append(errs, fmt.Errorf("templates '%s' does not exist", Config.Templates))
+	}
+	sfs := staticFS()
+	if _, err := NewTemplates(templatesFS(sfs)); err != nil {
+		errs = append(errs, err)
+	} else if _, err := fs.Stat(sfs, "js"); err != nil {
+		errs = append(errs, fmt.Errorf("static content does not have js area: %v", err))
+	}
+	for _, fname := range []string{Config.Hmac, Config.RootCA} {
+		if fname == "" {
+			continue
+		}
+		if _, err := os.Stat(fname); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if (Config.ServerCrt == "") != (Config.ServerKey == "") {
+		errs = append(errs, fmt.Errorf("both servercrt and serverkey should be provided"))
+	}
+	for _, fname := range []string{Config.ServerCrt, Config.ServerKey} {
+		if fname == "" {
+			continue
+		}
+		if _, err := os.Stat(fname); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
@@ -7,32 +7,53 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"log"
 )
 
 // Configuration stores configuration parameters
 type Configuration struct {
-	Port            int      `json:"port"`              // server port number
-	StaticDir       string   `json:"staticdir"`         // location of static directory
-	Base            string   `json:"base"`              // server base path
-	Verbose         int      `json:"verbose"`           // verbosity level
-	LogFile         string   `json:"log_file"`          // server log file (should ends with .log) or log area
-	Hmac            string   `json:"hmac"`              // cmsweb hmac file location
-	LimiterPeriod   string   `json:"limiter_rate"`      // limiter rate value
-	LimiterHeader   string   `json:"limiter_header"`    // limiter header to use
-	LimiterSkipList []string `json:"limiter_skip_list"` // limiter skip list
-	MetricsPrefix   string   `json:"metrics_prefix"`    // metrics prefix used for prometheus
-	CMSRole         string   `json:"cms_role"`          // cms role for write access
-	CMSGroup        string   `json:"cms_group"`         // cms group for write access
-	AccessURI       string   `json:"access_uri"`        // access URI, either URL or filename
+	Port             int      `json:"port"`              // server port number
+	StaticDir        string   `json:"staticdir"`         // location of static directory (overrides embedded one)
+	Base             string   `json:"base"`              // server base path
+	Verbose          int      `json:"verbose"`           // verbosity level
+	LogFile          string   `json:"log_file"`          // server log file (should ends with .log) or log area
+	LogFormat        string   `json:"log_format"`        // format of server logs: text (default) or json
+	LogLevel         string   `json:"log_level"`         // min level of logged records: debug, info (default), warn or error
+	Hmac             string   `json:"hmac"`              // cmsweb hmac file location
+	LimiterPeriod    string   `json:"limiter_rate"`      // limiter rate value
+	LimiterHeader    string   `json:"limiter_header"`    // limiter header to use
+	LimiterSkipList  []string `json:"limiter_skip_list"` // limiter skip list
+	MetricsPrefix    string   `json:"metrics_prefix"`    // metrics prefix used for prometheus
+	MetricsInterval  int      `json:"metrics_interval"`  // interval (in seconds) of background collection of system metrics, default 30
+	CMSRole          string   `json:"cms_role"`          // cms role for write access
+	CMSGroup         string   `json:"cms_group"`         // cms group for write access
+	AccessURI        string   `json:"access_uri"`        // access URI, either URL or filename
+	Sources          []Source `json:"sources"`           // list of labeled wmstats sources, overrides access_uri
+	MergeSources     bool     `json:"merge_sources"`     // merge all sources by default instead of using first one
+	RenewInterval    int64    `json:"renew_interval"`    // interval (in seconds) to renew wmstats data, default 300
+	FetchTimeout     int      `json:"fetch_timeout"`     // timeout (in seconds) of upstream requests, default 60
+	FetchRetries     *int     `json:"fetch_retries"`     // number of retries of failed upstream requests, default 2, 0 disables retries
+	FetchBackoff     int      `json:"fetch_backoff"`     // initial backoff (in seconds) between retries, default 1
+	CircuitThreshold int      `json:"circuit_threshold"` // number of consecutive failures which opens circuit breaker, default 5
+	CircuitCooldown  int      `json:"circuit_cooldown"`  // interval (in seconds) before open circuit breaker allows a trial request, default 60
+	StaleThreshold   int64    `json:"stale_threshold"`   // age (in seconds) after which wmstats data is reported as stale, default 900
+	ReadyMaxAge      int64    `json:"ready_max_age"`     // max time (in seconds) since last successful refresh of wmstats data of ready server, default 3600, negative disables the check
+	PushGateway      string   `json:"push_gateway"`      // URL of Prometheus Pushgateway to push wmstats metrics to after each refresh
+	RemoteWrite      string   `json:"remote_write"`      // URL of Prometheus remote-write endpoint to push wmstats metrics to after each refresh
+	PushJob          string   `json:"push_job"`          // job name of pushed wmstats metrics, default wmstats
+	PushTimeout      int      `json:"push_timeout"`      // timeout (in seconds) of push requests, default 10
+
+	// tracing parameters
+	OTLPEndpoint     string  `json:"otlp_endpoint"`      // URL of OTLP/HTTP collector to export traces to, e.g. http://localhost:4318
+	TraceSampleRatio float64 `json:"trace_sample_ratio"` // fraction of sampled traces, default 1
 
 	// server static parts
-	Templates string `json:"templates"` // location of server templates
-	Jscripts  string `json:"jscripts"`  // location of server JavaScript files
-	Images    string `json:"images"`    // location of server images
-	Styles    string `json:"styles"`    // location of server CSS styles
+	Templates      string `json:"templates"`       // location of server templates (overrides static ones)
+	TemplatesWatch bool   `json:"templates_watch"` // watch and reload templates (development mode)
+	Jscripts       string `json:"jscripts"`        // location of server JavaScript files
+	Images         string `json:"images"`          // location of server images
+	Styles         string `json:"styles"`          // location of server CSS styles
 
 	// security parts
 	ServerKey  string `json:"serverkey"`  // server key for https
@@ -45,6 +66,15 @@ type Configuration struct {
 // Config represents global configuration object
 var Config Configuration
 
+// WMStatsSources returns list of configured wmstats sources, if sources
+// are not configured the access URI is used as default source
+func (c *Configuration) WMStatsSources() []Source {
+	if len(c.Sources) == 0 && c.AccessURI != "" {
+		return []Source{{Label: "default", URI: c.AccessURI}}
+	}
+	return c.Sources
+}
+
 // String returns string representation of Config
 func (c *Configuration) String() string {
 	data, err := json.Marshal(c)
@@ -70,11 +100,14 @@ func ParseConfig(configFile string) error {
 	if Config.LimiterPeriod == "" {
 		Config.LimiterPeriod = "100-S"
 	}
-	if Config.Templates == "" {
-		Config.Templates = fmt.Sprintf("%s/templates", Config.StaticDir)
-	}
 	if Config.MetricsPrefix == "" {
 		Config.MetricsPrefix = "wmstats"
 	}
+	if Config.FetchTimeout == 0 {
+		Config.FetchTimeout = 60
+	}
+	if Config.ReadyMaxAge == 0 {
+		Config.ReadyMaxAge = 3600
+	}
 	return nil
 }
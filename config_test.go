@@ -0,0 +1,40 @@
+package main
+
+// config_test module provides unit tests of server configuration
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"testing"
+)
+
+// TestParseConfigFetchRetries tests that number of retries of upstream
+// requests is taken from configuration, including zero value
+func TestParseConfigFetchRetries(t *testing.T) {
+	defer func(config Configuration, retries, timeout int) {
+		Config, FetchRetries, TIMEOUT = config, retries, timeout
+	}(Config, FetchRetries, TIMEOUT)
+	tests := []struct {
+		name    string
+		config  string
+		retries int
+	}{
+		{"default", `{}`, 2},
+		{"no retries", `{"fetch_retries": 0}`, 0},
+		{"retries", `{"fetch_retries": 5}`, 5},
+		// negative value keeps default
+		{"negative", `{"fetch_retries": -1}`, 2},
+	}
+	for _, tt := range tests {
+		Config, FetchRetries = Configuration{}, 2
+		fname := writeTestFile(t, t.TempDir(), "config.json", []byte(tt.config))
+		if err := ParseConfig(fname); err != nil {
+			t.Fatal(err)
+		}
+		initFetchParameters()
+		if FetchRetries != tt.retries {
+			t.Errorf("%s: %d retries, expected %d", tt.name, FetchRetries, tt.retries)
+		}
+	}
+}
@@ -160,6 +160,7 @@ type AgentJobInfoMap map[string]AgentJobInfo
 type WMStats struct {
 	CMSSWVersion     string
 	Campaign         string
+	PrepID           string          `json:"PrepID"`
 	RequestStatus    string          `json:"RequestStatus"`
 	RequestPriority  float64         `json:"RequestPriority"`
 	RequestType      string          `json:"RequestType"`
@@ -177,10 +178,13 @@ type WorkflowInfo struct {
 	Name     string
 	Type     string
 	Campaign string
+	PrepID   string
 	Sites    []string
 	Agents   []string
 	Releases []string
 	Status   Status
+	State    string            // request status, e.g. running-open
+	SiteJobs map[string]Status // job status of workflow per site
 }
 
 // Workflow represents workflow data structure
@@ -219,6 +223,8 @@ type CMSSWStats struct {
 	FailureRate   float64
 	Requests      int
 	CoolOff       int
+	Running       int
+	Pending       int
 }
 
 // CMSSWSummary keeps information about CMSSW summary
@@ -236,6 +242,8 @@ type AgentStats struct {
 	JobProgress float64
 	Requests    int
 	CoolOff     int
+	Running     int
+	Pending     int
 }
 
 // AgentSummary keeps information about agent summary
@@ -257,6 +265,8 @@ type CampaignStats struct {
 	FailureRate   float64
 	Requests      int
 	CoolOff       int
+	Running       int
+	Pending       int
 }
 
 // CampaignSummary keeps information about campaign summary
@@ -287,14 +297,31 @@ func (cs *CampaignSummary) JobProgress() float64 {
 	}
 	return 100 * float64(cs.Status.Success+cs.Status.Failure.Sum()) / float64(totalJobs)
 }
+// EventProgress returns event progress of campaign, it is 0 for campaign without events
 func (cs *CampaignSummary) EventProgress() float64 {
+	if cs.TotalEvents == 0 {
+		return 0
+	}
 	return 100 * float64(cs.AvgEvents()) / float64(cs.TotalEvents)
 }
+
+// LumiProgress returns lumi progress of campaign, it is 0 for campaign without lumis
 func (cs *CampaignSummary) LumiProgress() float64 {
+	if cs.TotalLumis == 0 {
+		return 0
+	}
 	return 100 * float64(cs.AvgLumis()) / float64(cs.TotalLumis)
 }
+
+// FailureRate returns percentage of failed jobs among finished jobs of
+// campaign, it is 0 for campaign without finished jobs
 func (cs *CampaignSummary) FailureRate() float64 {
-	return 100 * float64(cs.Status.Failure.Sum()) / float64(cs.TotalJobs)
+	totalFailure := cs.Status.Failure.Sum()
+	totalJobs := cs.Status.Success + totalFailure
+	if totalJobs == 0 {
+		return 0
+	}
+	return 100 * float64(totalFailure) / float64(totalJobs)
 }
 func (cs *CampaignSummary) AvgEvents() float64 {
 	return 1
@@ -0,0 +1,45 @@
+package main
+
+// data_test module provides unit tests of wmstats data summaries
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"math"
+	"testing"
+)
+
+// TestCampaignSummary tests progress and failure rate of campaign summaries
+func TestCampaignSummary(t *testing.T) {
+	tests := []struct {
+		name     string
+		summary  CampaignSummary
+		events   float64
+		lumis    float64
+		failures float64
+	}{
+		{"empty campaign", CampaignSummary{}, 0, 0, 0},
+		{"no finished jobs", CampaignSummary{TotalEvents: 4, TotalLumis: 2,
+			Status: Status{Submitted: Submitted{Running: 5}}}, 25, 50, 0},
+		// failure rate is computed over finished jobs
+		{"finished jobs", CampaignSummary{TotalJobs: 100,
+			Status: Status{Success: 6, Failure: Failure{Exception: 1, Submit: 1}, Submitted: Submitted{Running: 92}}}, 0, 0, 25},
+	}
+	for _, tt := range tests {
+		values := []struct {
+			name     string
+			value    float64
+			expected float64
+		}{
+			{"event progress", tt.summary.EventProgress(), tt.events},
+			{"lumi progress", tt.summary.LumiProgress(), tt.lumis},
+			{"failure rate", tt.summary.FailureRate(), tt.failures},
+		}
+		for _, v := range values {
+			if math.IsNaN(v.value) || math.Abs(v.value-v.expected) > 1e-9 {
+				t.Errorf("%s: %s %v, expected %v", tt.name, v.name, v.value, v.expected)
+			}
+		}
+	}
+}
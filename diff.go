@@ -0,0 +1,164 @@
+package main
+
+// wmstats diff module provides comparison of two wmstats snapshots
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// DiffStats defines list of stats tables we compare
+var DiffStats = []string{"campaign", "site", "cmssw", "agent"}
+
+// DiffOptions represents options to suppress noise in snapshot comparison
+type DiffOptions struct {
+	Threshold     float64 // min absolute change of counters, e.g. running jobs
+	RateThreshold float64 // min absolute change of rates and progress (in percents)
+}
+
+// helper function to convert table value to float
+func toFloat(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case int:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case float64:
+		return val, true
+	}
+	return 0, false
+}
+
+// helper function to round delta values to avoid floating point noise
+func roundDelta(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+// helper function to build map of table rows keyed by first column value
+func tableRows(t *Table) (map[string]TableRow, []string) {
+	rows := make(map[string]TableRow)
+	var keys []string
+	for _, row := range t.Rows {
+		key := fmt.Sprintf("%v", row[0])
+		rows[key] = row
+		keys = append(keys, key)
+	}
+	return rows, keys
+}
+
+// diffTable returns table with empty set of delta rows
+func diffTable() *Table {
+	return &Table{
+		Name: "diff",
+		Columns: []Column{
+			{Name: "stats", Title: "Stats"},
+			{Name: "key", Title: "Key"},
+			{Name: "change", Title: "Change"},
+			{Name: "attribute", Title: "Attribute"},
+			{Name: "old", Title: "Old"},
+			{Name: "new", Title: "New"},
+			{Name: "delta", Title: "Delta"},
+		},
+	}
+}
+
+// WMStatsDiff compares two wmstats snapshots and returns table of deltas.
+// It reports new and vanished keys of campaign, site, cmssw and agent
+// tables, changes of their numeric attributes above given thresholds and
+// workflows which changed their status.
+func WMStatsDiff(oldInfo, newInfo *WMStatsInfo, opts DiffOptions) *Table {
+	out := diffTable()
+	for _, stats := range DiffStats {
+		oldTable := oldInfo.Table(stats)
+		newTable := newInfo.Table(stats)
+		oldRows, oldKeys := tableRows(oldTable)
+		newRows, newKeys := tableRows(newTable)
+		keys := append(oldKeys, newKeys...)
+		sort.Strings(keys)
+		for i, key := range keys {
+			if i > 0 && keys[i-1] == key {
+				continue
+			}
+			oldRow, inOld := oldRows[key]
+			newRow, inNew := newRows[key]
+			if !inOld {
+				out.Rows = append(out.Rows, TableRow{stats, key, "new", "", "", "", ""})
+				continue
+			}
+			if !inNew {
+				out.Rows = append(out.Rows, TableRow{stats, key, "vanished", "", "", "", ""})
+				continue
+			}
+			for idx, col := range newTable.Columns {
+				if idx == 0 {
+					continue
+				}
+				oldVal, ok1 := toFloat(oldRow[idx])
+				newVal, ok2 := toFloat(newRow[idx])
+				if !ok1 || !ok2 {
+					continue
+				}
+				delta := roundDelta(newVal - oldVal)
+				threshold := opts.Threshold
+				if isRateColumn(stats, col.Name) {
+					threshold = opts.RateThreshold
+				}
+				if delta == 0 || math.Abs(delta) < threshold {
+					continue
+				}
+				row := TableRow{stats, key, "changed", col.Name, oldRow[idx], newRow[idx], delta}
+				out.Rows = append(out.Rows, row)
+			}
+		}
+	}
+
+	// workflows which changed their status
+	var workflows []string
+	for name := range newInfo.Workflows {
+		workflows = append(workflows, name)
+	}
+	sort.Strings(workflows)
+	for _, name := range workflows {
+		winfo := newInfo.Workflows[name]
+		if oinfo, ok := oldInfo.Workflows[name]; ok && oinfo.State != winfo.State {
+			row := TableRow{"workflow", name, "status", "status", oinfo.State, winfo.State, ""}
+			out.Rows = append(out.Rows, row)
+		}
+	}
+	out.Total = len(out.Rows)
+	return out
+}
+
+// cliDiff provides CLI interface to compare two wmstats snapshots
+func cliDiff(opts CliOptions, oldFile, newFile string, dopts DiffOptions) error {
+	if err := opts.Setup(); err != nil {
+		return err
+	}
+	filters := wmstatsFilters(opts.Filters)
+	oldInfo, oldFresh, err := loadWMStatsInfo(oldFile, filters, opts.Verbose)
+	if err != nil {
+		return err
+	}
+	newInfo, newFresh, err := loadWMStatsInfo(newFile, filters, opts.Verbose)
+	if err != nil {
+		return err
+	}
+	// rows are stable sorted by stats column by default, i.e. deltas
+	// within the same stats table preserve their order
+	t := WMStatsDiff(oldInfo, newInfo, dopts).Apply(opts.TableOptions())
+	if err := WriteTable(os.Stdout, t, opts.Format); err != nil {
+		return err
+	}
+	// report freshness of both snapshots and warn if they are swapped
+	writeFreshness(os.Stderr, &oldFresh)
+	writeFreshness(os.Stderr, &newFresh)
+	if newFresh.Updated.Before(oldFresh.Updated) {
+		fmt.Fprintf(os.Stderr, "WARNING: new snapshot %s is older than old snapshot %s\n", newFile, oldFile)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+// diff_test module provides unit tests of wmstats snapshots comparison
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// helper function to create wmstats snapshots to compare
+func testSnapshots() (*WMStatsInfo, *WMStatsInfo) {
+	oldInfo := &WMStatsInfo{
+		SiteStatsMap: SiteStatsMap{
+			"T1_US_FNAL": {Requests: 5, Running: 10, Pending: 2, FailureRate: 1.0},
+			"T2_CH_CERN": {Requests: 1},
+		},
+		AgentStatsMap: AgentStatsMap{
+			"vocms0250": {Requests: 3, FailureRate: 3},
+		},
+		Workflows: map[string]WorkflowInfo{
+			"wf1": {State: "running-open"},
+			"wf2": {State: "running-closed"},
+		},
+	}
+	newInfo := &WMStatsInfo{
+		SiteStatsMap: SiteStatsMap{
+			"T1_US_FNAL": {Requests: 5, Running: 12, Pending: 2, FailureRate: 1.5},
+			"T2_US_MIT":  {Requests: 2},
+		},
+		AgentStatsMap: AgentStatsMap{
+			"vocms0250": {Requests: 3, FailureRate: 50},
+		},
+		Workflows: map[string]WorkflowInfo{
+			"wf1": {State: "completed"},
+			"wf2": {State: "running-closed"},
+			"wf3": {State: "new"},
+		},
+	}
+	return oldInfo, newInfo
+}
+
+// TestWMStatsDiff tests comparison of wmstats snapshots with thresholds
+func TestWMStatsDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     DiffOptions
+		expected []string
+	}{
+		{"no thresholds", DiffOptions{}, []string{
+			"site T1_US_FNAL changed running 2",
+			"site T1_US_FNAL changed failure_rate 0.5",
+			"site T2_CH_CERN vanished  ",
+			"site T2_US_MIT new  ",
+			"agent vocms0250 changed failure_rate 47",
+			"workflow wf1 status status ",
+		}},
+		{"counter threshold", DiffOptions{Threshold: 5}, []string{
+			"site T1_US_FNAL changed failure_rate 0.5",
+			"site T2_CH_CERN vanished  ",
+			"site T2_US_MIT new  ",
+			"agent vocms0250 changed failure_rate 47",
+			"workflow wf1 status status ",
+		}},
+		// failure rate of agents is a number of failed jobs, i.e. a counter
+		{"rate threshold", DiffOptions{Threshold: 100, RateThreshold: 1}, []string{
+			"site T2_CH_CERN vanished  ",
+			"site T2_US_MIT new  ",
+			"workflow wf1 status status ",
+		}},
+		{"delta equals threshold", DiffOptions{Threshold: 2, RateThreshold: 0.5}, []string{
+			"site T1_US_FNAL changed running 2",
+			"site T1_US_FNAL changed failure_rate 0.5",
+			"site T2_CH_CERN vanished  ",
+			"site T2_US_MIT new  ",
+			"agent vocms0250 changed failure_rate 47",
+			"workflow wf1 status status ",
+		}},
+	}
+	for _, tt := range tests {
+		oldInfo, newInfo := testSnapshots()
+		out := WMStatsDiff(oldInfo, newInfo, tt.opts)
+		var rows []string
+		for _, row := range out.Rows {
+			rows = append(rows, fmt.Sprintf("%v %v %v %v %v", row[0], row[1], row[2], row[3], row[6]))
+		}
+		if !reflect.DeepEqual(rows, tt.expected) {
+			t.Errorf("%s: got\n%q\nexpected\n%q", tt.name, rows, tt.expected)
+		}
+		if out.Total != len(out.Rows) {
+			t.Errorf("%s: total %d, expected %d", tt.name, out.Total, len(out.Rows))
+		}
+	}
+
+	// old and new values of changed attributes and statuses are reported
+	oldInfo, newInfo := testSnapshots()
+	out := WMStatsDiff(oldInfo, newInfo, DiffOptions{})
+	if row := out.Rows[0]; row[4] != 10 || row[5] != 12 {
+		t.Errorf("wrong values of changed attribute %v", row)
+	}
+	if row := out.Rows[len(out.Rows)-1]; row[4] != "running-open" || row[5] != "completed" {
+		t.Errorf("wrong values of changed status %v", row)
+	}
+
+	// identical snapshots have no deltas
+	if out := WMStatsDiff(newInfo, newInfo, DiffOptions{}); len(out.Rows) != 0 {
+		t.Errorf("deltas of identical snapshots %v", out.Rows)
+	}
+}
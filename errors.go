@@ -0,0 +1,53 @@
+package main
+
+// wmstats errors module
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoData is returned when wmstats data is not yet available
+var ErrNoData = errors.New("wmstats data is not yet available")
+
+// ErrNotReady is returned when wmstats info of requested namespace is not yet built
+var ErrNotReady = errors.New("WMStats data is not yet ready, please retry")
+
+// ErrUnknownSource is returned when requested wmstats namespace does not exist
+var ErrUnknownSource = errors.New("unknown wmstats source")
+
+// DataError represents error of wmstats data, e.g. malformed JSON
+type DataError struct {
+	URI string // wmstats input
+	Err error  // underlying error
+}
+
+// Error implements error interface
+func (e *DataError) Error() string {
+	return fmt.Sprintf("invalid wmstats data of %s: %v", e.URI, e.Err)
+}
+
+// Unwrap returns underlying error
+func (e *DataError) Unwrap() error {
+	return e.Err
+}
+
+// CredentialsError represents error of user credentials, i.e. X509 proxy,
+// certificates or token
+type CredentialsError struct {
+	Source string // credentials source, e.g. file name of the token
+	Err    error  // underlying error
+}
+
+// Error implements error interface
+func (e *CredentialsError) Error() string {
+	return fmt.Sprintf("invalid credentials %s: %v", e.Source, e.Err)
+}
+
+// Unwrap returns underlying error
+func (e *CredentialsError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,122 @@
+package main
+
+// errors_test module provides unit tests of wmstats errors
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestDataError tests that malformed wmstats data is reported as data error
+// while server keeps serving last good snapshot and reports degraded state
+func TestDataError(t *testing.T) {
+	defer func() { wSources = nil }()
+	good := []byte(`{"result":[{"wf1":{"RequestName":"wf1","Campaign":"Run2022A","RequestStatus":"running-open"}}]}`)
+	fname := writeTestFile(t, t.TempDir(), "wmstats.json", good)
+	sources, err := NewWMStatsSources([]Source{{"prod", fname}}, false, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wSources = sources
+	refreshWMStats(context.Background(), sources, StartTime)
+	mgr := sources.Managers["prod"]
+	updated := mgr.Snapshot().Updated
+
+	// healthy source
+	w := httptest.NewRecorder()
+	StatusHandler(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Errorf("wrong status of healthy server %d %q", w.Code, w.Body.String())
+	}
+
+	// malformed data keeps last good snapshot
+	writeTestFile(t, filepath.Dir(fname), "wmstats.json", []byte(`{"result":[{"wf1":`))
+	refreshWMStats(context.Background(), sources, StartTime)
+	snapshot := mgr.Snapshot()
+	var derr *DataError
+	if !errors.As(snapshot.Error, &derr) || derr.URI != fname {
+		t.Errorf("wrong error of malformed data %v", snapshot.Error)
+	}
+	if string(snapshot.Data) != string(good) || !snapshot.Updated.Equal(updated) {
+		t.Errorf("last good snapshot is not kept, data %s, updated %v", snapshot.Data, snapshot.Updated)
+	}
+	info, err := wmstatsInfo(context.Background(), "prod", nil)
+	if err != nil || len(info.Workflows) != 1 {
+		t.Errorf("last good wmstats info is not served, error %v", err)
+	}
+
+	// server reports degraded state along with its reason
+	reasons := degradedReasons("")
+	if len(reasons) != 1 || !strings.HasPrefix(reasons[0], "source prod: invalid wmstats data of "+fname) ||
+		!strings.Contains(reasons[0], "serving data of") {
+		t.Errorf("wrong degraded reasons %v", reasons)
+	}
+	w = httptest.NewRecorder()
+	StatusHandler(w, httptest.NewRequest("GET", "/healthz", nil))
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.HasPrefix(body, "degraded\n") || !strings.Contains(body, reasons[0]) {
+		t.Errorf("wrong status of degraded server %d %q", w.Code, body)
+	}
+
+	// data which can't be decoded into wmstats records is data error too
+	wmgr := &WMStatsManager{URI: fname, Data: []byte(`{"result":{}}`)}
+	if _, err := wmstats(context.Background(), wmgr, nil, 0); !errors.As(err, &derr) {
+		t.Errorf("wrong error of undecodable data %v", err)
+	}
+
+	// the source is healthy again once data is fixed
+	writeTestFile(t, filepath.Dir(fname), "wmstats.json", good)
+	refreshWMStats(context.Background(), sources, StartTime)
+	if reasons := degradedReasons(""); len(reasons) != 0 {
+		t.Errorf("degraded reasons of healthy source %v", reasons)
+	}
+}
+
+// TestCredentialsError tests that missing credentials are reported as
+// credentials error which is not retried
+func TestCredentialsError(t *testing.T) {
+	if u, err := user.Current(); err == nil {
+		if _, err := os.Stat("/tmp/x509up_u" + u.Uid); err == nil {
+			t.Skip("X509 proxy of current user is available")
+		}
+	}
+	token := Token
+	defer func() { Token = token }()
+	Token = ""
+	t.Setenv("X509_USER_PROXY", filepath.Join(t.TempDir(), "missing.proxy"))
+	t.Setenv("X509_USER_KEY", "")
+	t.Setenv("X509_USER_CERT", "")
+
+	var cerr *CredentialsError
+	if _, err := HttpClient(); !errors.As(err, &cerr) || cerr.Source != "X509" {
+		t.Errorf("wrong error of missing proxy %v", err)
+	}
+	retries := atomic.LoadUint64(&FetchRetriesTotal)
+	_, _, err := fetchWithRetries(context.Background(), "https://localhost/wmstats", FetchValidators{})
+	if !errors.As(err, &cerr) {
+		t.Errorf("wrong fetch error of missing proxy %v", err)
+	}
+	if n := atomic.LoadUint64(&FetchRetriesTotal) - retries; n != 0 {
+		t.Errorf("%d retries of request without credentials", n)
+	}
+
+	// token file which can't be read, e.g. directory
+	dir := t.TempDir()
+	if _, err := readToken(dir); !errors.As(err, &cerr) || cerr.Source != dir {
+		t.Errorf("wrong error of unreadable token %v", err)
+	}
+	if tkn, err := readToken("plain-token"); err != nil || tkn != "plain-token" {
+		t.Errorf("wrong token %q, error %v", tkn, err)
+	}
+}
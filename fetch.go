@@ -7,11 +7,13 @@ package main
 
 import (
 	"compress/gzip"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"mime"
 	"net/http"
 	"os"
 	"os/user"
@@ -20,6 +22,8 @@ import (
 	"time"
 
 	"github.com/vkuznet/x509proxy"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 // TIMEOUT defines timeout for net/url request
@@ -35,13 +39,13 @@ var TLSCertsRenewInterval time.Duration
 type TLSCertsManager struct {
 	Certs  []tls.Certificate
 	Expire time.Time
+	mutex  sync.Mutex
 }
 
 // GetCerts return fresh copy of certificates
 func (t *TLSCertsManager) GetCerts() ([]tls.Certificate, error) {
-	var lock = sync.Mutex{}
-	lock.Lock()
-	defer lock.Unlock()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
 	// we'll use existing certs if our window is not expired
 	if t.Certs == nil || time.Since(t.Expire) > TLSCertsRenewInterval {
 		t.Expire = time.Now()
@@ -57,13 +61,23 @@ func (t *TLSCertsManager) GetCerts() ([]tls.Certificate, error) {
 					t.Expire = ts
 				}
 			} else {
-				log.Fatal("ERROR ", err.Error())
+				// allow to re-read certificates on next call
+				t.Expire = time.Time{}
+				return nil, &CredentialsError{Source: "X509", Err: err}
 			}
 		}
 	}
 	return t.Certs, nil
 }
 
+// Expiration returns expiration time of current certificates, zero time
+// is returned if certificates are not loaded
+func (t *TLSCertsManager) Expiration() time.Time {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return CertExpire(t.Certs)
+}
+
 // CertExpire gets minimum certificate expire from list of certificates
 func CertExpire(certs []tls.Certificate) time.Time {
 	var notAfter time.Time
@@ -116,76 +130,169 @@ func tlsCerts() ([]tls.Certificate, error) {
 }
 
 // helper function to either read file content or return given string
-func readToken(r string) string {
+func readToken(r string) (string, error) {
 	if _, err := os.Stat(r); err == nil {
 		b, e := os.ReadFile(r)
 		if e != nil {
-			log.Fatalf("Unable to read data from file: %s, error: %s", r, e)
+			return "", &CredentialsError{Source: r, Err: e}
 		}
-		return strings.Replace(string(b), "\n", "", -1)
+		return strings.Replace(string(b), "\n", "", -1), nil
 	}
-	return r
+	return r, nil
 }
 
 // HttpClient is HTTP client for urlfetch server
-func HttpClient() *http.Client {
+func HttpClient() (*http.Client, error) {
 	var certs []tls.Certificate
 	var err error
 	if Token == "" { // if there is no token back auth we fall back to x509
 		// get X509 certs
 		certs, err = tlsManager.GetCerts()
 		if err != nil {
-			log.Fatal("ERROR ", err.Error())
+			return nil, err
 		}
 	}
 	timeout := time.Duration(TIMEOUT) * time.Second
 	if len(certs) == 0 {
 		if TIMEOUT > 0 {
-			return &http.Client{Timeout: time.Duration(timeout)}
+			return &http.Client{Timeout: time.Duration(timeout)}, nil
 		}
-		return &http.Client{}
+		return &http.Client{}, nil
 	}
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{Certificates: certs,
 			InsecureSkipVerify: true},
 	}
 	if TIMEOUT > 0 {
-		return &http.Client{Transport: tr, Timeout: timeout}
+		return &http.Client{Transport: tr, Timeout: timeout}, nil
+	}
+	return &http.Client{Transport: tr}, nil
+}
+
+// ErrNotModified is returned by conditional fetch when upstream data is not modified
+var ErrNotModified = errors.New("not modified")
+
+// FetchError represents error of HTTP request to upstream server
+type FetchError struct {
+	URL         string // request URL
+	StatusCode  int    // HTTP status code of the response
+	ContentType string // content type of the response
+	Reason      string // failure reason, e.g. response body snippet
+}
+
+// Error implements error interface
+func (e *FetchError) Error() string {
+	msg := fmt.Sprintf("fetch %s: %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.ContentType != "" {
+		msg += fmt.Sprintf(", content-type %s", e.ContentType)
+	}
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+// FetchValidators represents HTTP cache validators of upstream response
+type FetchValidators struct {
+	ETag         string // ETag header of the response
+	LastModified string // Last-Modified header of the response
+}
+
+// helper function to check if given content type represents JSON data
+func isJSONContent(ctype string) bool {
+	if ctype == "" {
+		return true
+	}
+	mtype, _, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		return false
 	}
-	return &http.Client{Transport: tr}
+	return mtype == "application/json" || mtype == "text/json" || strings.HasSuffix(mtype, "+json")
 }
 
-// fetch fetches data for provided URL, args is a json dump of arguments
+// helper function to get short snippet of response body used in error messages
+func bodySnippet(data []byte) string {
+	s := strings.Join(strings.Fields(string(data)), " ")
+	if len(s) > 200 {
+		s = s[:200] + "..."
+	}
+	return s
+}
+
+// fetch fetches data for provided URL, failed requests are retried
 func fetch(rurl string) ([]byte, error) {
-	var req *http.Request
-	req, _ = http.NewRequest("GET", rurl, nil)
+	data, _, err := fetchWithRetries(context.Background(), rurl, FetchValidators{})
+	return data, err
+}
+
+// fetchConditional fetches data for provided URL using validators of previous
+// response. It returns ErrNotModified if upstream data is not changed, and
+// FetchError if upstream responds with non-OK status code or non-JSON data.
+func fetchConditional(ctx context.Context, rurl string, validators FetchValidators) ([]byte, FetchValidators, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rurl, nil)
+	if err != nil {
+		return nil, validators, err
+	}
+	// propagate trace context to upstream
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("Connection", "Keep-Alive")
 	req.Header.Add("Keep-Alive", "timeout=5, max=1000")
 	req.Header.Add("Accept-Encoding", "gzip")
+	if id := requestID(ctx); id != "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
+	if validators.ETag != "" {
+		req.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		req.Header.Set("If-Modified-Since", validators.LastModified)
+	}
 	if Token != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", readToken(Token)))
+		token, err := readToken(Token)
+		if err != nil {
+			return nil, validators, err
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+	client, err := HttpClient()
+	if err != nil {
+		return nil, validators, err
 	}
-	client := HttpClient()
 	resp, err := client.Do(req)
 	if err != nil {
-		return []byte{}, err
+		return nil, validators, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, validators, ErrNotModified
+	}
 	var data []byte
 	// check if we got gzipped content
 	if resp.Header.Get("Content-Encoding") == "gzip" {
 		var gz *gzip.Reader
 		gz, err = gzip.NewReader(resp.Body)
 		if err != nil {
-			return []byte{}, err
-		} else {
-			defer gz.Close()
-			data, err = ioutil.ReadAll(gz)
+			return nil, validators, err
 		}
+		defer gz.Close()
+		data, err = ioutil.ReadAll(gz)
 	} else {
 		data, err = ioutil.ReadAll(resp.Body)
 	}
-
-	return data, err
+	if err != nil {
+		return nil, validators, err
+	}
+	ctype := resp.Header.Get("Content-Type")
+	if resp.StatusCode != http.StatusOK {
+		return nil, validators, &FetchError{URL: rurl, StatusCode: resp.StatusCode, ContentType: ctype, Reason: bodySnippet(data)}
+	}
+	if !isJSONContent(ctype) {
+		return nil, validators, &FetchError{URL: rurl, StatusCode: resp.StatusCode, ContentType: ctype, Reason: "unexpected content type: " + bodySnippet(data)}
+	}
+	validators = FetchValidators{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	return data, validators, nil
 }
@@ -0,0 +1,146 @@
+package main
+
+// fetch_test module provides unit tests of conditional fetch of wmstats data
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// helper function to start test upstream server which supports conditional
+// requests via ETag and Last-Modified validators
+func testUpstream(t *testing.T, etag, modified string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/error":
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		case "/html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>login page</html>"))
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", modified)
+		if r.Header.Get("If-None-Match") == etag || r.Header.Get("If-Modified-Since") == modified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/gzip" {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			gz.Write([]byte(`{"result":[]}`))
+			gz.Close()
+			return
+		}
+		w.Write([]byte(`{"result":[]}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// TestFetchConditional tests conditional fetch of upstream data
+func TestFetchConditional(t *testing.T) {
+	token := Token
+	Token = "test-token"
+	defer func() { Token = token }()
+	etag, modified := `"v1"`, "Mon, 02 Jan 2006 15:04:05 GMT"
+	srv := testUpstream(t, etag, modified)
+	tests := []struct {
+		name       string
+		path       string
+		validators FetchValidators
+		data       string
+		status     int
+		err        error
+	}{
+		{"first fetch", "/data", FetchValidators{}, `{"result":[]}`, 0, nil},
+		{"gzip response", "/gzip", FetchValidators{}, `{"result":[]}`, 0, nil},
+		{"same etag", "/data", FetchValidators{ETag: etag}, "", 0, ErrNotModified},
+		{"same last-modified", "/data", FetchValidators{LastModified: modified}, "", 0, ErrNotModified},
+		{"stale etag", "/data", FetchValidators{ETag: `"v0"`}, `{"result":[]}`, 0, nil},
+		{"server error", "/error", FetchValidators{}, "", http.StatusInternalServerError, nil},
+		{"non-JSON response", "/html", FetchValidators{}, "", http.StatusOK, nil},
+	}
+	for _, tt := range tests {
+		data, validators, err := fetchConditional(context.Background(), srv.URL+tt.path, tt.validators)
+		if tt.status != 0 {
+			var ferr *FetchError
+			if !errors.As(err, &ferr) || ferr.StatusCode != tt.status {
+				t.Errorf("%s: wrong error %v", tt.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got error %v, expected %v", tt.name, err, tt.err)
+			continue
+		}
+		if string(data) != tt.data {
+			t.Errorf("%s: got %q, expected %q", tt.name, data, tt.data)
+		}
+		// validators of new data are taken from response, otherwise kept as is
+		expected := tt.validators
+		if tt.err == nil {
+			expected = FetchValidators{ETag: etag, LastModified: modified}
+		}
+		if validators != expected {
+			t.Errorf("%s: got validators %+v, expected %+v", tt.name, validators, expected)
+		}
+	}
+}
+
+// TestWMStatsManagerNotModified tests that not modified upstream data keeps
+// last snapshot of wmstats manager
+func TestWMStatsManagerNotModified(t *testing.T) {
+	token := Token
+	Token = "test-token"
+	defer func() { Token = token }()
+	srv := testUpstream(t, `"v1"`, "Mon, 02 Jan 2006 15:04:05 GMT")
+	wmgr := NewWMStatsManager(srv.URL+"/data", -1)
+	wmgr.update()
+	first := wmgr.Snapshot()
+	if first.Error != nil || string(first.Data) != `{"result":[]}` || first.Validators.ETag != `"v1"` {
+		t.Fatalf("wrong first snapshot %+v", first)
+	}
+	wmgr.update()
+	second := wmgr.Snapshot()
+	if second.Error != nil || string(second.Data) != `{"result":[]}` {
+		t.Errorf("wrong snapshot of not modified data %+v", second)
+	}
+	if !second.Updated.Equal(first.Updated) || second.Checked.Before(first.Checked) {
+		t.Errorf("not modified data changed update time %v -> %v", first.Updated, second.Updated)
+	}
+	if second.Refreshes != 2 || second.Failures != 0 {
+		t.Errorf("wrong refresh counters %d/%d", second.Refreshes, second.Failures)
+	}
+
+	// snapshot which upstream confirms as not modified is not stale
+	old := time.Now().Add(-time.Duration(2*StaleThreshold) * time.Second)
+	wmgr.mutex.Lock()
+	wmgr.Updated, wmgr.Checked = old, old
+	wmgr.mutex.Unlock()
+	if f := wmgr.Freshness(); !f.Stale {
+		t.Errorf("old snapshot is not stale %+v", f)
+	}
+	wmgr.update()
+	f := wmgr.Freshness()
+	if f.Stale || f.Age > 1 || f.Failed {
+		t.Errorf("not modified snapshot is stale %+v", f)
+	}
+	if third := wmgr.Snapshot(); !third.Updated.Equal(old) {
+		t.Errorf("not modified data changed update time %v -> %v", old, third.Updated)
+	}
+}
@@ -6,7 +6,6 @@ package main
 //
 
 import (
-	"fmt"
 	"strings"
 )
 
@@ -28,15 +27,3 @@ func wmstatsFilters(values string) WMStatsFilters {
 	}
 	return filters
 }
-
-// helper function to convert list of filters to HTML format
-func filtersToHTML(filters WMStatsFilters) string {
-	var s string
-	for k, v := range filters {
-		// TODO: make close icon to discard the filter
-		// so the action should turn off the span
-		f := fmt.Sprintf("<span class=\"alert is-focus\">%s=%s</span>", k, v)
-		s += fmt.Sprintf("%s<br/>", f)
-	}
-	return s
-}
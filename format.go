@@ -0,0 +1,161 @@
+package main
+
+// wmstats output formats module
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// OutputFormats defines list of supported output formats
+var OutputFormats = []string{"table", "json", "ndjson", "csv", "tsv", "markdown", "yaml"}
+
+// helper function to format table value in machine readable form
+func formatValue(v interface{}) string {
+	switch val := v.(type) {
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case string:
+		return val
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// helper function to check if given value is a number
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case int, int64, float64:
+		return true
+	}
+	return false
+}
+
+// WriteTable writes given table to provided writer in given format
+func WriteTable(w io.Writer, t *Table, format string) error {
+	switch strings.ToLower(format) {
+	case "", "table":
+		return writeText(w, t)
+	case "json":
+		return writeJSONTable(w, t)
+	case "ndjson":
+		return writeNDJSON(w, t)
+	case "csv":
+		return writeCSV(w, t, ',')
+	case "tsv":
+		return writeCSV(w, t, '\t')
+	case "markdown", "md":
+		return writeMarkdown(w, t)
+	case "yaml", "yml":
+		return writeYAML(w, t)
+	}
+	return fmt.Errorf("unsupported format '%s', supported formats: %s", format, strings.Join(OutputFormats, ", "))
+}
+
+// helper function to write table as aligned text table
+func writeText(w io.Writer, t *Table) error {
+	return NewTextRenderer(w).Render(w, t)
+}
+
+// helper function to write table in JSON data-format
+func writeJSONTable(w io.Writer, t *Table) error {
+	data, err := json.MarshalIndent(t, "", "   ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// helper function to write table rows as new-line delimited JSON records
+func writeNDJSON(w io.Writer, t *Table) error {
+	enc := json.NewEncoder(w)
+	for _, rec := range t.Records() {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helper function to write table in CSV data-format with given separator
+func writeCSV(w io.Writer, t *Table, sep rune) error {
+	writer := csv.NewWriter(w)
+	writer.Comma = sep
+	var headers []string
+	for _, c := range t.Columns {
+		headers = append(headers, c.Name)
+	}
+	if err := writer.Write(headers); err != nil {
+		return err
+	}
+	for _, row := range t.Rows {
+		var vals []string
+		for _, v := range row {
+			vals = append(vals, formatValue(v))
+		}
+		if err := writer.Write(vals); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// helper function to write table in Markdown data-format
+func writeMarkdown(w io.Writer, t *Table) error {
+	escape := func(s string) string {
+		return strings.ReplaceAll(s, "|", "\\|")
+	}
+	var headers, aligns []string
+	for i, c := range t.Columns {
+		headers = append(headers, escape(c.Title))
+		if len(t.Rows) > 0 && isNumber(t.Rows[0][i]) {
+			aligns = append(aligns, "---:")
+		} else {
+			aligns = append(aligns, "---")
+		}
+	}
+	out := fmt.Sprintf("| %s |\n", strings.Join(headers, " | "))
+	out += fmt.Sprintf("| %s |\n", strings.Join(aligns, " | "))
+	for _, row := range t.Rows {
+		var vals []string
+		for _, v := range row {
+			vals = append(vals, escape(formatValue(v)))
+		}
+		out += fmt.Sprintf("| %s |\n", strings.Join(vals, " | "))
+	}
+	_, err := io.WriteString(w, out)
+	return err
+}
+
+// helper function to write table in YAML data-format, strings are
+// written as double-quoted scalars to preserve column order and types
+func writeYAML(w io.Writer, t *Table) error {
+	if len(t.Rows) == 0 {
+		_, err := fmt.Fprintln(w, "[]")
+		return err
+	}
+	var out string
+	for _, row := range t.Rows {
+		for i, v := range row {
+			prefix := "  "
+			if i == 0 {
+				prefix = "- "
+			}
+			val := formatValue(v)
+			if !isNumber(v) {
+				val = strconv.Quote(val)
+			}
+			out += fmt.Sprintf("%s%s: %s\n", prefix, t.Columns[i].Name, val)
+		}
+	}
+	_, err := io.WriteString(w, out)
+	return err
+}
@@ -0,0 +1,112 @@
+package main
+
+// format_test module provides unit tests of CLI output formats
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// helper function to create test table for output formats
+func testFormatTable() *Table {
+	t := &Table{
+		Name: "site-stats",
+		Columns: []Column{
+			{Name: "site", Title: "Site"},
+			{Name: "requests", Title: "Requests"},
+			{Name: "failure_rate", Title: "Failure Rate"},
+		},
+		Rows: []TableRow{
+			{"T1,US|FNAL", 10, 2.5},
+			{`T2 "CH"`, 0, 0.0},
+		},
+	}
+	return t.Apply(TableOptions{})
+}
+
+// TestWriteTable tests text based output formats
+func TestWriteTable(t *testing.T) {
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{"table", "Site       Requests Failure Rate\n" +
+			"T1,US|FNAL       10         2.50\n" +
+			"T2 \"CH\"           0         0.00\n"},
+		{"csv", "site,requests,failure_rate\n" +
+			"\"T1,US|FNAL\",10,2.5\n" +
+			"\"T2 \"\"CH\"\"\",0,0\n"},
+		{"tsv", "site\trequests\tfailure_rate\n" +
+			"T1,US|FNAL\t10\t2.5\n" +
+			"\"T2 \"\"CH\"\"\"\t0\t0\n"},
+		{"markdown", "| Site | Requests | Failure Rate |\n" +
+			"| --- | ---: | ---: |\n" +
+			"| T1,US\\|FNAL | 10 | 2.5 |\n" +
+			"| T2 \"CH\" | 0 | 0 |\n"},
+		{"yaml", "- site: \"T1,US|FNAL\"\n" +
+			"  requests: 10\n" +
+			"  failure_rate: 2.5\n" +
+			"- site: \"T2 \\\"CH\\\"\"\n" +
+			"  requests: 0\n" +
+			"  failure_rate: 0\n"},
+		{"ndjson", "{\"failure_rate\":2.5,\"requests\":10,\"site\":\"T1,US|FNAL\"}\n" +
+			"{\"failure_rate\":0,\"requests\":0,\"site\":\"T2 \\\"CH\\\"\"}\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := WriteTable(&buf, testFormatTable(), tt.format); err != nil {
+			t.Errorf("%s: %v", tt.format, err)
+			continue
+		}
+		if buf.String() != tt.expected {
+			t.Errorf("%s: got\n%s\nexpected\n%s", tt.format, buf.String(), tt.expected)
+		}
+	}
+
+	// empty table in YAML data-format is an empty list
+	var buf bytes.Buffer
+	if err := WriteTable(&buf, &Table{}, "yaml"); err != nil || buf.String() != "[]\n" {
+		t.Errorf("wrong YAML of empty table %q, error %v", buf.String(), err)
+	}
+
+	// unsupported formats are reported as errors
+	if err := WriteTable(&buf, testFormatTable(), "xml"); err == nil {
+		t.Errorf("no error for unsupported format")
+	}
+}
+
+// TestWriteTableJSON tests JSON output format
+func TestWriteTableJSON(t *testing.T) {
+	var buf bytes.Buffer
+	tab := testFormatTable()
+	if err := WriteTable(&buf, tab, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var rec struct {
+		Name    string                   `json:"name"`
+		Columns []string                 `json:"columns"`
+		Total   int                      `json:"total"`
+		Rows    []map[string]interface{} `json:"rows"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Name != "site-stats" || rec.Total != 2 {
+		t.Errorf("wrong table name %s or total %d", rec.Name, rec.Total)
+	}
+	if !reflect.DeepEqual(rec.Columns, []string{"site", "requests", "failure_rate"}) {
+		t.Errorf("wrong columns %v", rec.Columns)
+	}
+	expected := []map[string]interface{}{
+		{"site": "T1,US|FNAL", "requests": 10.0, "failure_rate": 2.5},
+		{"site": `T2 "CH"`, "requests": 0.0, "failure_rate": 0.0},
+	}
+	if !reflect.DeepEqual(rec.Rows, expected) {
+		t.Errorf("wrong rows %v", rec.Rows)
+	}
+}
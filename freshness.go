@@ -0,0 +1,154 @@
+package main
+
+// wmstats freshness module provides information about age of wmstats data
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// StaleThreshold defines age (in seconds) after which wmstats data is
+// considered stale, 0 disables staleness check
+var StaleThreshold int64 = 900
+
+// Freshness represents freshness of wmstats data snapshot
+type Freshness struct {
+	Source    string    `json:"source"`          // source label or merged namespace
+	URI       string    `json:"uri"`             // upstream URI(s) of the data
+	Updated   time.Time `json:"updated"`         // fetch (or last check) time of the snapshot
+	Age       int64     `json:"age"`             // age of the snapshot in seconds
+	Duration  float64   `json:"duration"`        // duration of last refresh in seconds
+	Workflows int       `json:"workflows"`       // number of workflows in the snapshot
+	Failed    bool      `json:"failed"`          // last refresh failed
+	Error     string    `json:"error,omitempty"` // error of last refresh
+	Stale     bool      `json:"stale"`           // snapshot is older than stale threshold
+}
+
+// helper function to set age and staleness of freshness record
+func (f Freshness) now() Freshness {
+	f.Age = 0
+	if !f.Updated.IsZero() {
+		f.Age = int64(time.Since(f.Updated).Seconds())
+	}
+	f.Stale = StaleThreshold > 0 && !f.Updated.IsZero() && f.Age > StaleThreshold
+	return f
+}
+
+// Freshness returns freshness of wmstats data of the manager, the data of
+// local files is as fresh as the files rather than time they are read and
+// remote data is as fresh as last check of upstream
+func (w *WMStatsManager) Freshness() Freshness {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	f := Freshness{
+		Source:   w.Label,
+		URI:      w.URI,
+		Updated:  w.Updated,
+		Duration: w.Duration.Seconds(),
+		Failed:   w.Error != nil,
+	}
+	if !w.Modified.IsZero() {
+		f.Updated = w.Modified
+	} else if w.Checked.After(f.Updated) {
+		// upstream confirmed that remote data is not modified since the
+		// snapshot was fetched, i.e. the snapshot is as fresh as the check
+		f.Updated = w.Checked
+	}
+	if w.Error != nil {
+		f.Error = w.Error.Error()
+	}
+	return f.now()
+}
+
+// Freshness returns freshness of given namespace, the merged namespace is
+// as fresh as its oldest source and its refresh fails if any source fails
+func (s *WMStatsSources) Freshness(label string) (Freshness, error) {
+	label = s.label(label)
+	if label != MergedSource {
+		mgr, ok := s.Managers[label]
+		if !ok {
+			return Freshness{}, fmt.Errorf("%w '%s'", ErrUnknownSource, label)
+		}
+		return mgr.Freshness(), nil
+	}
+	f := Freshness{Source: MergedSource}
+	var uris, errs []string
+	for _, l := range s.Labels {
+		sf := s.Managers[l].Freshness()
+		uris = append(uris, sf.URI)
+		if !sf.Updated.IsZero() && (f.Updated.IsZero() || sf.Updated.Before(f.Updated)) {
+			f.Updated = sf.Updated
+		}
+		if sf.Duration > f.Duration {
+			f.Duration = sf.Duration
+		}
+		if sf.Failed {
+			f.Failed = true
+			errs = append(errs, fmt.Sprintf("%s: %s", l, sf.Error))
+		}
+	}
+	f.URI = strings.Join(uris, ",")
+	f.Error = strings.Join(errs, "; ")
+	return f.now(), nil
+}
+
+// helper function to format age of wmstats data in human readable form
+func formatAge(age int64) string {
+	return (time.Duration(age) * time.Second).String()
+}
+
+// String returns human readable representation of freshness record
+func (f Freshness) String() string {
+	if f.Updated.IsZero() {
+		return fmt.Sprintf("wmstats data of %s is not yet available", f.Source)
+	}
+	source := f.Source
+	if f.URI != "" && f.URI != f.Source {
+		source = fmt.Sprintf("%s (%s)", f.Source, f.URI)
+	}
+	return fmt.Sprintf("wmstats data of %s fetched at %s, %s ago, refresh took %.3fs, %d workflows",
+		source, f.Updated.Format(time.RFC3339), formatAge(f.Age), f.Duration, f.Workflows)
+}
+
+// Warnings returns list of warnings about freshness of wmstats data
+func (f Freshness) Warnings() []string {
+	var out []string
+	if f.Stale {
+		out = append(out, fmt.Sprintf("wmstats data is stale, it is older than %s", formatAge(StaleThreshold)))
+	}
+	if f.Failed {
+		out = append(out, fmt.Sprintf("last refresh of wmstats data failed: %s", f.Error))
+	}
+	return out
+}
+
+// helper function to write freshness of wmstats data to given writer, it is
+// used by CLI to report data freshness on stderr
+func writeFreshness(w io.Writer, f *Freshness) {
+	if f == nil {
+		return
+	}
+	fmt.Fprintln(w, f.String())
+	for _, msg := range f.Warnings() {
+		fmt.Fprintln(w, "WARNING:", msg)
+	}
+}
+
+// helper function to set freshness HTTP headers of API response
+func setFreshnessHeaders(w http.ResponseWriter, f Freshness) {
+	w.Header().Set("X-WMStats-Source", f.Source)
+	if !f.Updated.IsZero() {
+		w.Header().Set("X-WMStats-Updated", f.Updated.UTC().Format(time.RFC3339))
+	}
+	w.Header().Set("X-WMStats-Age", fmt.Sprintf("%d", f.Age))
+	w.Header().Set("X-WMStats-Refresh-Duration", fmt.Sprintf("%.3f", f.Duration))
+	w.Header().Set("X-WMStats-Workflows", fmt.Sprintf("%d", f.Workflows))
+	w.Header().Set("X-WMStats-Refresh-Failed", fmt.Sprintf("%v", f.Failed))
+	w.Header().Set("X-WMStats-Stale", fmt.Sprintf("%v", f.Stale))
+}
@@ -3,12 +3,21 @@ package main
 // handlers.go - provides handlers examples for wmstats server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // HTTPError represents HTTP error structure
@@ -33,32 +42,273 @@ type ServerError struct {
 }
 
 // helper function to parse given template and return HTML page
-func tmplPage(tmpl string, tmplData TmplRecord) string {
+func tmplPage(ctx context.Context, tmpl string, tmplData TmplRecord) string {
 	if tmplData == nil {
 		tmplData = make(TmplRecord)
 	}
-	var templates Templates
-	page := templates.Tmpl(Config.Templates, tmpl, tmplData)
+	_, span := startSpan(ctx, "render", attribute.String("wmstats.template", tmpl))
+	page, err := _templates.Render(tmpl, tmplData)
+	if err != nil {
+		logError(ctx, "unable to render template", "template", tmpl, "error", err)
+	}
+	endSpan(span, err)
 	return page
 }
 
-// MetricsHandler provides metrics
-func MetricsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+// wmstatsInfoCache represents wmstats info of a source along with time of
+// the source update it was built from and error of last rebuild
+type wmstatsInfoCache struct {
+	Info    *WMStatsInfo
+	Updated time.Time
+	Error   error
+}
+
+// global cache of wmstats info objects keyed by source label along with
+// channels of in-flight builds of wmstats info objects
+var _wmstatsInfo = make(map[string]wmstatsInfoCache)
+var _wmstatsInfoBuilds = make(map[string]chan struct{})
+var _wmstatsInfoLock sync.Mutex
+
+// helper function to get wmstats info of given source, it refreshes cached
+// wmstats info object when source data is updated or filters are provided
+func wmstatsInfo(ctx context.Context, source string, filters WMStatsFilters) (*WMStatsInfo, error) {
+	mgr, err := wSources.Manager(source)
+	if err != nil {
+		return nil, err
+	}
+	var info *WMStatsInfo
+	if len(filters) > 0 {
+		info, err = wmstats(ctx, mgr, filters, 0)
+	} else {
+		cache := buildWMStatsInfo(ctx, mgr)
+		info, err = cache.Info, cache.Error
+	}
+	if info == nil {
+		if err == nil || errors.Is(err, ErrNoData) {
+			return nil, ErrNotReady
+		}
+		return nil, err
+	}
+	return info, nil
+}
+
+// helper function to get cached wmstats info of given namespace, it never
+// builds wmstats info and returns nil if info is not yet built
+func cachedWMStatsInfo(label string) *WMStatsInfo {
+	_wmstatsInfoLock.Lock()
+	defer _wmstatsInfoLock.Unlock()
+	return _wmstatsInfo[label].Info
+}
+
+// helper function to build cached wmstats info of given manager snapshot.
+// The info is built outside of cache lock and concurrent callers of the
+// same namespace wait for a single build rather than repeat it.
+func buildWMStatsInfo(ctx context.Context, mgr *WMStatsManager) wmstatsInfoCache {
+	_wmstatsInfoLock.Lock()
+	cache, ok := _wmstatsInfo[mgr.Label]
+	if ok && cache.Info != nil && !mgr.Updated.After(cache.Updated) {
+		_wmstatsInfoLock.Unlock()
+		return cache
+	}
+	done, building := _wmstatsInfoBuilds[mgr.Label]
+	if !building {
+		done = make(chan struct{})
+		_wmstatsInfoBuilds[mgr.Label] = done
+	}
+	_wmstatsInfoLock.Unlock()
+	if building {
+		<-done
+		_wmstatsInfoLock.Lock()
+		defer _wmstatsInfoLock.Unlock()
+		return _wmstatsInfo[mgr.Label]
+	}
+
+	winfo, werr := wmstats(ctx, mgr, nil, 0)
+	if werr != nil && !errors.Is(werr, ErrNoData) {
+		logError(ctx, "unable to process WMStats data", "source", mgr.Label, "error", werr)
+	}
+	_wmstatsInfoLock.Lock()
+	defer _wmstatsInfoLock.Unlock()
+	cache = _wmstatsInfo[mgr.Label]
+	// keep last good wmstats info if new data can't be processed and
+	// never replace info of newer data
+	if !cache.Updated.After(mgr.Updated) {
+		if werr == nil {
+			cache.Info = winfo
+		}
+		cache.Updated = mgr.Updated
+		cache.Error = werr
+		_wmstatsInfo[mgr.Label] = cache
+	}
+	delete(_wmstatsInfoBuilds, mgr.Label)
+	close(done)
+	return cache
+}
+
+// helper function to get list of reasons why given namespace of wmstats
+// data is degraded, i.e. its sources fail to update or their data can't
+// be processed. Empty namespace means all sources.
+func degradedReasons(source string) []string {
+	var reasons []string
+	for _, status := range wSources.Status() {
+		if source != "" && source != MergedSource && source != status.Label {
+			continue
+		}
+		if status.Error == "" {
+			continue
+		}
+		reason := fmt.Sprintf("source %s: %s", status.Label, status.Error)
+		if !status.Updated.IsZero() {
+			reason += fmt.Sprintf(", serving data of %s", status.Updated.Format(time.RFC3339))
+		}
+		reasons = append(reasons, reason)
+	}
+	_wmstatsInfoLock.Lock()
+	defer _wmstatsInfoLock.Unlock()
+	var labels []string
+	for label := range _wmstatsInfo {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		cache := _wmstatsInfo[label]
+		if source != "" && source != label {
+			continue
+		}
+		if cache.Error != nil && !errors.Is(cache.Error, ErrNoData) {
+			reasons = append(reasons, fmt.Sprintf("namespace %s: %v", label, cache.Error))
+		}
+	}
+	return reasons
+}
+
+// helper function to get freshness of wmstats data of given namespace, the
+// number of workflows is taken from cached wmstats info of the namespace
+func dataFreshness(source string) (Freshness, error) {
+	label := wSources.label(source)
+	fresh, err := wSources.Freshness(label)
+	if err != nil {
+		return fresh, err
+	}
+	_wmstatsInfoLock.Lock()
+	defer _wmstatsInfoLock.Unlock()
+	if cache, ok := _wmstatsInfo[label]; ok {
+		if cache.Info != nil {
+			fresh.Workflows = len(cache.Info.Workflows)
+		}
+		if cache.Error != nil && !errors.Is(cache.Error, ErrNoData) {
+			fresh.Failed = true
+			if fresh.Error != "" {
+				fresh.Error += "; "
+			}
+			fresh.Error += cache.Error.Error()
+		}
+	}
+	return fresh, nil
+}
+
+// helper function to write table in JSON data-format along with freshness
+// of wmstats data of given namespace
+func writeTableJSON(w http.ResponseWriter, t *Table, source string) {
+	if fresh, err := dataFreshness(source); err == nil {
+		setFreshnessHeaders(w, fresh)
+		t.Freshness = &fresh
+	}
+	writeJSON(w, t)
+}
+
+// helper function to setup freshness banner of page template
+func freshnessTmpl(ctx context.Context, tmpl TmplRecord, source string) {
+	fresh, err := dataFreshness(source)
+	if err != nil {
+		return
+	}
+	ftmpl := make(TmplRecord)
+	ftmpl["Freshness"] = fresh
+	ftmpl["Age"] = formatAge(fresh.Age)
+	ftmpl["Warnings"] = fresh.Warnings()
+	tmpl["Freshness"] = template.HTML(tmplPage(ctx, "freshness.tmpl", ftmpl))
+}
+
+// helper function to setup source parts of page template
+func sourcesTmpl(ctx context.Context, tmpl TmplRecord, source string) {
+	tmpl["Source"] = source
+	tmpl["Degraded"] = degradedReasons(source)
+	freshnessTmpl(ctx, tmpl, source)
+	if len(wSources.Labels) > 1 {
+		stmpl := make(TmplRecord)
+		stmpl["Base"] = Config.Base
+		stmpl["Source"] = source
+		if source == "" {
+			if mgr, err := wSources.Manager(""); err == nil {
+				stmpl["Source"] = mgr.Label
+			}
+		}
+		stmpl["MergedSource"] = MergedSource
+		stmpl["Sources"] = wSources.Status()
+		tmpl["Sources"] = template.HTML(tmplPage(ctx, "sources.tmpl", stmpl))
+	}
+}
+
+// helper function to write given object in JSON data-format
+func writeJSON(w http.ResponseWriter, rec interface{}) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(promMetrics(Config.MetricsPrefix)))
-	return
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
 }
 
-// global pointer to wmstats info
-var _wmstatsInfo *WMStatsInfo
+// helper function to get HTTP status code of given error, the wmstats data
+// which is not yet available is reported as temporary unavailability
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrUnknownSource):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrNotReady), errors.Is(err, ErrNoData):
+		return http.StatusServiceUnavailable
+	}
+	var derr *DataError
+	if errors.As(err, &derr) {
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
 
-// ErrorHandler provides access to error page
-func ErrorHandler(w http.ResponseWriter, r *http.Request, msg string) {
-	data := []byte(msg)
+// ErrorHandler provides access to error page, the error is written in JSON
+// data-format if it is requested by the client
+func ErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	code := errorStatus(err)
+	logWarn(r.Context(), "request failed", "path", r.URL.Path, "code", code, "error", err)
+	if code == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "5")
+	}
+	if r.URL.Query().Get("format") != "json" && !strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(code)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	rec := ServerError{
+		HTTPError: HTTPError{
+			Method:         r.Method,
+			HTTPCode:       code,
+			Timestamp:      time.Now().String(),
+			Path:           r.URL.Path,
+			UserAgent:      r.Header.Get("User-Agent"),
+			XForwardedHost: r.Header.Get("X-Forwarded-Host"),
+			XForwardedFor:  r.Header.Get("X-Forwarded-For"),
+			RemoteAddr:     r.RemoteAddr,
+		},
+		Exception: code,
+		Type:      http.StatusText(code),
+		Message:   err.Error(),
+	}
+	data, _ := json.Marshal(rec)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
 	w.Write(data)
 }
 
@@ -68,44 +318,39 @@ func MainHandler(w http.ResponseWriter, r *http.Request) {
 	stats := query.Get("stats")
 	filters := wmstatsFilters(query.Get("filters"))
 
+	source := query.Get("source")
+
 	// get data
-	if _wmstatsInfo == nil || wMgr.TTL < time.Now().Unix() || len(filters) > 0 {
-		_wmstatsInfo = wmstats(wMgr, filters, 0)
-	}
-	if _wmstatsInfo == nil {
-		msg := "WMStats data is not yet ready, please retry"
-		ErrorHandler(w, r, msg)
+	info, err := wmstatsInfo(r.Context(), source, filters)
+	if err != nil {
+		ErrorHandler(w, r, err)
 		return
 	}
 
-	var table string
-	if stats == "agent" {
-		table = _wmstatsInfo.AgentStatsMap.HTMLTable()
-	} else if stats == "site" {
-		table = _wmstatsInfo.SiteStatsMap.HTMLTable()
-	} else if stats == "cmssw" {
-		table = _wmstatsInfo.CMSSWStatsMap.HTMLTable()
-	} else if stats == "campaign" {
-		table = _wmstatsInfo.CampaignStatsMap.HTMLTable()
-	} else {
-		table = _wmstatsInfo.CampaignStatsMap.HTMLTable()
+	t := info.Table(stats).Apply(tableOptions(query))
+	if query.Get("format") == "json" {
+		writeTableJSON(w, t, source)
+		return
 	}
+	table := HTMLTable(r.Context(), t, r.URL.Path, query)
 
 	// create temaplate
 	tmpl := make(TmplRecord)
 	tmpl["Base"] = Config.Base
 	tmpl["ServerInfo"] = ServerInfo
 	tmpl["Table"] = template.HTML(table)
-	tmpl["Menu"] = template.HTML(tmplPage("menu.tmpl", tmpl))
-	//     tmpl["Search"] = template.HTML(tmplPage("search.tmpl", tmpl))
+	sourcesTmpl(r.Context(), tmpl, source)
+	tmpl["Menu"] = template.HTML(tmplPage(r.Context(), "menu.tmpl", tmpl))
+	tmpl["Search"] = template.HTML(tmplPage(r.Context(), "search.tmpl", tmpl))
 	tmpl["Query"] = query.Get("filters")
-	tmpl["Filter"] = template.HTML(tmplPage("filters.tmpl", tmpl))
-	tmpl["AppliedFilters"] = template.HTML(filtersToHTML(filters))
-	tmpl["Header"] = _header
-	tmpl["Footer"] = _footer
+	tmpl["Filter"] = template.HTML(tmplPage(r.Context(), "filters.tmpl", tmpl))
+	tmpl["AppliedFilters"] = filters
+	parts := pageParts()
+	tmpl["Header"] = parts.Header
+	tmpl["Footer"] = parts.Footer
 
-	page := tmplPage("main.tmpl", tmpl)
-	w.Write([]byte(string(_top) + page + string(_bottom)))
+	page := tmplPage(r.Context(), "main.tmpl", tmpl)
+	w.Write([]byte(string(parts.Top) + page + string(parts.Bottom)))
 }
 
 // AlertsHandler provides access to alerts page of server
@@ -114,12 +359,14 @@ func AlertsHandler(w http.ResponseWriter, r *http.Request) {
 	tmpl := make(TmplRecord)
 	tmpl["Base"] = Config.Base
 	tmpl["ServerInfo"] = ServerInfo
-	tmpl["Menu"] = template.HTML(tmplPage("menu.tmpl", tmpl))
-	tmpl["Header"] = _header
-	tmpl["Footer"] = _footer
+	freshnessTmpl(r.Context(), tmpl, r.URL.Query().Get("source"))
+	tmpl["Menu"] = template.HTML(tmplPage(r.Context(), "menu.tmpl", tmpl))
+	parts := pageParts()
+	tmpl["Header"] = parts.Header
+	tmpl["Footer"] = parts.Footer
 
-	page := tmplPage("alerts.tmpl", tmpl)
-	w.Write([]byte(string(_top) + page + string(_bottom)))
+	page := tmplPage(r.Context(), "alerts.tmpl", tmpl)
+	w.Write([]byte(string(parts.Top) + page + string(parts.Bottom)))
 }
 
 // AgentsHandler provides access to agents page of server
@@ -128,12 +375,14 @@ func AgentsHandler(w http.ResponseWriter, r *http.Request) {
 	tmpl := make(TmplRecord)
 	tmpl["Base"] = Config.Base
 	tmpl["ServerInfo"] = ServerInfo
-	tmpl["Menu"] = template.HTML(tmplPage("menu.tmpl", tmpl))
-	tmpl["Header"] = _header
-	tmpl["Footer"] = _footer
+	freshnessTmpl(r.Context(), tmpl, r.URL.Query().Get("source"))
+	tmpl["Menu"] = template.HTML(tmplPage(r.Context(), "menu.tmpl", tmpl))
+	parts := pageParts()
+	tmpl["Header"] = parts.Header
+	tmpl["Footer"] = parts.Footer
 
-	page := tmplPage("agents.tmpl", tmpl)
-	w.Write([]byte(string(_top) + page + string(_bottom)))
+	page := tmplPage(r.Context(), "agents.tmpl", tmpl)
+	w.Write([]byte(string(parts.Top) + page + string(parts.Bottom)))
 }
 
 // ErrorLogsHandler provides access to error logs page of server
@@ -142,12 +391,14 @@ func ErrorLogsHandler(w http.ResponseWriter, r *http.Request) {
 	tmpl := make(TmplRecord)
 	tmpl["Base"] = Config.Base
 	tmpl["ServerInfo"] = ServerInfo
-	tmpl["Menu"] = template.HTML(tmplPage("menu.tmpl", tmpl))
-	tmpl["Header"] = _header
-	tmpl["Footer"] = _footer
+	freshnessTmpl(r.Context(), tmpl, r.URL.Query().Get("source"))
+	tmpl["Menu"] = template.HTML(tmplPage(r.Context(), "menu.tmpl", tmpl))
+	parts := pageParts()
+	tmpl["Header"] = parts.Header
+	tmpl["Footer"] = parts.Footer
 
-	page := tmplPage("errorlogs.tmpl", tmpl)
-	w.Write([]byte(string(_top) + page + string(_bottom)))
+	page := tmplPage(r.Context(), "errorlogs.tmpl", tmpl)
+	w.Write([]byte(string(parts.Top) + page + string(parts.Bottom)))
 }
 
 // WorkflowsHandler provides access to workflows page of server
@@ -157,58 +408,142 @@ func WorkflowsHandler(w http.ResponseWriter, r *http.Request) {
 	site := query.Get("site")
 	cmssw := query.Get("cmssw")
 	agent := query.Get("agent")
+	prepid := query.Get("prepid")
 	filters := wmstatsFilters(query.Get("filters"))
+	source := query.Get("source")
 
 	// get data
-	if _wmstatsInfo == nil || wMgr.TTL < time.Now().Unix() || len(filters) > 0 {
-		_wmstatsInfo = wmstats(wMgr, filters, 0)
-	}
-	if _wmstatsInfo == nil {
-		msg := "WMStats data is not yet ready, please retry"
-		ErrorHandler(w, r, msg)
+	info, err := wmstatsInfo(r.Context(), source, filters)
+	if err != nil {
+		ErrorHandler(w, r, err)
 		return
 	}
 
 	table := "Unkown key"
-	title := ""
+	var workflows []Workflow
+	var found bool
+	var key, value string
 	if campaign != "" {
-		if workflows, ok := _wmstatsInfo.CampaignWorkflows[campaign]; ok {
-			table = workflowHTMLTable(workflows)
-			val := fmt.Sprintf("<span class=\"alert is-focus\">%s</span>", campaign)
-			title = fmt.Sprintf("<h4>Workflows associated with %s campaign</h4>", val)
-		}
+		key, value = "campaign", campaign
+		workflows, found = info.WorkflowsBy("campaign", campaign)
 	} else if site != "" {
-		if workflows, ok := _wmstatsInfo.SiteWorkflows[site]; ok {
-			table = workflowHTMLTable(workflows)
-			val := fmt.Sprintf("<span class=\"alert is-focus\">%s</span>", site)
-			title = fmt.Sprintf("<h4>Workflows associated with %s site</h4>", val)
-		}
+		key, value = "site", site
+		workflows, found = info.WorkflowsBy("site", site)
 	} else if cmssw != "" {
-		if workflows, ok := _wmstatsInfo.CMSSWWorkflows[cmssw]; ok {
-			table = workflowHTMLTable(workflows)
-			val := fmt.Sprintf("<span class=\"alert is-focus\">%s</span>", cmssw)
-			title = fmt.Sprintf("<h4>Workflows associated with with %s</h4>", val)
-		}
+		key, value = "release", cmssw
+		workflows, found = info.WorkflowsBy("cmssw", cmssw)
 	} else if agent != "" {
-		if workflows, ok := _wmstatsInfo.AgentWorkflows[agent]; ok {
-			table = workflowHTMLTable(workflows)
-			val := fmt.Sprintf("<span class=\"alert is-focus\">%s</span>", agent)
-			title = fmt.Sprintf("<h4>Workflows associated with %s agent</h4>", val)
-		}
+		key, value = "agent", agent
+		workflows, found = info.WorkflowsBy("agent", agent)
+	} else if prepid != "" {
+		key, value = "prep id", prepid
+		workflows, found = info.WorkflowsBy("prepid", prepid)
+	}
+	t := workflowTable(workflows).Apply(tableOptions(query))
+	if query.Get("format") == "json" {
+		writeTableJSON(w, t, source)
+		return
+	}
+	if found {
+		table = HTMLTable(r.Context(), t, r.URL.Path, query)
 	}
 
 	// create temaplate
 	tmpl := make(TmplRecord)
 	tmpl["Base"] = Config.Base
 	tmpl["ServerInfo"] = ServerInfo
-	tmpl["Menu"] = template.HTML(tmplPage("menu.tmpl", tmpl))
-	tmpl["Header"] = _header
-	tmpl["Footer"] = _footer
-	tmpl["Title"] = template.HTML(title)
+	sourcesTmpl(r.Context(), tmpl, source)
+	tmpl["Menu"] = template.HTML(tmplPage(r.Context(), "menu.tmpl", tmpl))
+	tmpl["Search"] = template.HTML(tmplPage(r.Context(), "search.tmpl", tmpl))
+	parts := pageParts()
+	tmpl["Header"] = parts.Header
+	tmpl["Footer"] = parts.Footer
+	if found {
+		tmpl["TitleKey"] = key
+		tmpl["TitleValue"] = value
+	}
 	tmpl["Table"] = template.HTML(table)
 
-	page := tmplPage("main.tmpl", tmpl)
-	w.Write([]byte(string(_top) + page + string(_bottom)))
+	page := tmplPage(r.Context(), "main.tmpl", tmpl)
+	w.Write([]byte(string(parts.Top) + page + string(parts.Bottom)))
+}
+
+// helper function to provide link for a given search entry
+func searchLink(entry SearchEntry) string {
+	if entry.Type == "workflow" {
+		return reqmgrLink(entry.Value)
+	}
+	return workflowsLink(entry.Type)(entry.Value)
+}
+
+// helper function to get integer value of query parameter
+func queryInt(query url.Values, key string, def int) int {
+	if val, err := strconv.Atoi(query.Get(key)); err == nil && val >= 0 {
+		return val
+	}
+	return def
+}
+
+// SearchHandler provides access to search page of server
+func SearchHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := strings.TrimSpace(query.Get("q"))
+	limit := queryInt(query, "limit", 50)
+
+	source := query.Get("source")
+
+	// get data
+	info, err := wmstatsInfo(r.Context(), source, nil)
+	if err != nil {
+		ErrorHandler(w, r, err)
+		return
+	}
+	groups := info.SearchIndex.Search(q, limit)
+	for i, g := range groups {
+		for j, e := range g.Entries {
+			groups[i].Entries[j].Link = sourceLink(searchLink(e), source)
+		}
+	}
+
+	// create temaplate
+	tmpl := make(TmplRecord)
+	tmpl["Base"] = Config.Base
+	tmpl["ServerInfo"] = ServerInfo
+	tmpl["Query"] = q
+	tmpl["Groups"] = groups
+	sourcesTmpl(r.Context(), tmpl, source)
+	tmpl["Menu"] = template.HTML(tmplPage(r.Context(), "menu.tmpl", tmpl))
+	tmpl["Search"] = template.HTML(tmplPage(r.Context(), "search.tmpl", tmpl))
+	parts := pageParts()
+	tmpl["Header"] = parts.Header
+	tmpl["Footer"] = parts.Footer
+	tmpl["Table"] = template.HTML(tmplPage(r.Context(), "searchresults.tmpl", tmpl))
+
+	page := tmplPage(r.Context(), "main.tmpl", tmpl)
+	w.Write([]byte(string(parts.Top) + page + string(parts.Bottom)))
+}
+
+// SuggestHandler provides type-ahead suggestions in JSON data-format
+func SuggestHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := queryInt(query, "limit", 20)
+	entries := []SearchEntry{}
+	source := query.Get("source")
+	if info, err := wmstatsInfo(r.Context(), source, nil); err == nil {
+		for _, e := range info.SearchIndex.Suggest(query.Get("q"), limit) {
+			e.Link = sourceLink(searchLink(e), source)
+			entries = append(entries, e)
+		}
+	}
+	if fresh, err := dataFreshness(source); err == nil {
+		setFreshnessHeaders(w, fresh)
+	}
+	writeJSON(w, entries)
+}
+
+// SourcesHandler provides health status of wmstats sources in JSON data-format
+func SourcesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, wSources.Status())
 }
 
 // StatusHandler provides basic functionality of status response
@@ -218,6 +553,18 @@ func StatusHandler(w http.ResponseWriter, r *http.Request) {
 	//     if err != nil {
 	//         log.Fatalf("Fail to marshal records, %v", err)
 	//     }
+	if ready, reasons := readiness(); !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("not ready\n" + strings.Join(reasons, "\n") + "\n"))
+		return
+	}
+	// report degraded state along with its reasons, the server still
+	// serves last good wmstats snapshot in this case
+	if reasons := degradedReasons(""); len(reasons) > 0 {
+		data := []byte("degraded\n" + strings.Join(reasons, "\n") + "\n")
+		w.Write(data)
+		return
+	}
 	data := []byte("ok")
 	w.Write(data)
 }
@@ -0,0 +1,66 @@
+package main
+
+// handlers_test module provides unit tests of server handlers
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestErrorHandler tests status codes and formats of error responses
+func TestErrorHandler(t *testing.T) {
+	tests := []struct {
+		err    error
+		code   int
+		accept string
+		url    string
+		json   bool
+	}{
+		{ErrNotReady, http.StatusServiceUnavailable, "", "/", false},
+		{ErrNoData, http.StatusServiceUnavailable, "", "/?format=json", true},
+		{fmt.Errorf("%w '%s'", ErrUnknownSource, "bla"), http.StatusBadRequest, "", "/", false},
+		{&DataError{URI: "file.json", Err: errors.New("bad JSON")}, http.StatusBadGateway, "application/json", "/", true},
+		{errors.New("error"), http.StatusInternalServerError, "text/html", "/", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.url, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		ErrorHandler(w, r, tt.err)
+		if w.Code != tt.code {
+			t.Errorf("%v: got status %d, expected %d", tt.err, w.Code, tt.code)
+		}
+		if (w.Header().Get("Retry-After") != "") != (tt.code == http.StatusServiceUnavailable) {
+			t.Errorf("%v: wrong Retry-After header %q", tt.err, w.Header().Get("Retry-After"))
+		}
+		if !tt.json {
+			if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") || w.Body.String() != tt.err.Error() {
+				t.Errorf("%v: wrong text response %q", tt.err, w.Body.String())
+			}
+			continue
+		}
+		var rec struct {
+			HTTP struct {
+				Code int `json:"code"`
+			} `json:"http"`
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &rec); err != nil {
+			t.Errorf("%v: %v", tt.err, err)
+			continue
+		}
+		if w.Header().Get("Content-Type") != "application/json" || rec.HTTP.Code != tt.code || rec.Message != tt.err.Error() {
+			t.Errorf("%v: wrong JSON response %s", tt.err, w.Body.String())
+		}
+	}
+}
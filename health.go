@@ -0,0 +1,159 @@
+package main
+
+// health module provides liveness, readiness and status APIs of the server
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// FetchStats represents counters of upstream requests
+type FetchStats struct {
+	Attempts        uint64 `json:"attempts"`         // total number of upstream requests
+	Failures        uint64 `json:"failures"`         // total number of failed upstream requests
+	Retries         uint64 `json:"retries"`          // total number of retries of upstream requests
+	CircuitRejected uint64 `json:"circuit_rejected"` // total number of requests rejected by open circuit breaker
+}
+
+// BuildInfo represents build information of the server
+type BuildInfo struct {
+	Version   string `json:"version"`            // git version of the code
+	GoVersion string `json:"go_version"`         // Go version used to build the server
+	Revision  string `json:"revision,omitempty"` // VCS revision of the build
+	Time      string `json:"time,omitempty"`     // VCS commit time of the build
+}
+
+// ServerStatus represents detailed status of the server
+type ServerStatus struct {
+	Status       string         `json:"status"`                   // ok, degraded or not ready
+	Ready        bool           `json:"ready"`                    // server is ready to serve wmstats data
+	Reasons      []string       `json:"reasons,omitempty"`        // reasons of degraded or not ready status
+	StartTime    time.Time      `json:"start_time"`               // server start time
+	Uptime       float64        `json:"uptime"`                   // server uptime in seconds
+	Freshness    Freshness      `json:"freshness"`                // freshness of default namespace
+	Sources      []SourceStatus `json:"sources"`                  // status of wmstats sources
+	Fetch        FetchStats     `json:"fetch"`                    // counters of upstream requests
+	CertExpire   *time.Time     `json:"cert_expire,omitempty"`    // expiration time of X509 certificates
+	CertExpireIn int64          `json:"cert_expire_in,omitempty"` // time (in seconds) left before certificates expire
+	Goroutines   int            `json:"goroutines"`               // number of goroutines
+	Build        BuildInfo      `json:"build"`                    // build information
+}
+
+// helper function to get build info of the server
+func buildInfo() BuildInfo {
+	b := BuildInfo{Version: version, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				b.Revision = s.Value
+			case "vcs.time":
+				b.Time = s.Value
+			}
+		}
+	}
+	return b
+}
+
+// helper function to check if server is ready, i.e. wmstats data of
+// default namespace is loaded and it is refreshed within configured max age.
+// It returns list of reasons if server is not ready.
+func readiness() (bool, []string) {
+	if wSources == nil {
+		return false, []string{"wmstats sources are not initialized"}
+	}
+	fresh, err := wSources.Freshness("")
+	if err != nil {
+		return false, []string{err.Error()}
+	}
+	if fresh.Updated.IsZero() {
+		return false, []string{fmt.Sprintf("wmstats data of %s is not yet loaded", fresh.Source)}
+	}
+	if Config.ReadyMaxAge <= 0 {
+		return true, nil
+	}
+	// the max age applies to last successful refresh (or check) of the
+	// sources rather than age of the data, e.g. old local dumps are ready
+	// as long as they are successfully read
+	var checked time.Time
+	for _, status := range wSources.Status() {
+		if fresh.Source != MergedSource && fresh.Source != status.Label {
+			continue
+		}
+		if !status.Checked.IsZero() && (checked.IsZero() || status.Checked.Before(checked)) {
+			checked = status.Checked
+		}
+	}
+	if age := int64(time.Since(checked).Seconds()); age > Config.ReadyMaxAge {
+		return false, []string{fmt.Sprintf("wmstats data of %s is not refreshed for %s, max age is %s",
+			fresh.Source, formatAge(age), formatAge(Config.ReadyMaxAge))}
+	}
+	return true, nil
+}
+
+// LiveHandler provides liveness probe of the server, it only reports that
+// server process is running and able to handle requests
+func LiveHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+// ReadyHandler provides readiness probe of the server, it returns
+// StatusServiceUnavailable until wmstats data is loaded or if it is not refreshed for too long
+func ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	ready, reasons := readiness()
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("not ready\n"))
+		for _, reason := range reasons {
+			w.Write([]byte(reason + "\n"))
+		}
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// ServerStatusHandler provides detailed status of the server in JSON data-format
+func ServerStatusHandler(w http.ResponseWriter, r *http.Request) {
+	ready, reasons := readiness()
+	status := ServerStatus{
+		Status:     "ok",
+		Ready:      ready,
+		Reasons:    reasons,
+		StartTime:  StartTime,
+		Uptime:     time.Since(StartTime).Seconds(),
+		Goroutines: runtime.NumGoroutine(),
+		Build:      buildInfo(),
+		Fetch: FetchStats{
+			Attempts:        atomic.LoadUint64(&FetchAttempts),
+			Failures:        atomic.LoadUint64(&FetchFailures),
+			Retries:         atomic.LoadUint64(&FetchRetriesTotal),
+			CircuitRejected: atomic.LoadUint64(&FetchCircuitRejected),
+		},
+	}
+	if wSources != nil {
+		status.Sources = wSources.Status()
+		if fresh, err := dataFreshness(""); err == nil {
+			status.Freshness = fresh
+		}
+		if degraded := degradedReasons(""); len(degraded) > 0 {
+			status.Status = "degraded"
+			status.Reasons = append(status.Reasons, degraded...)
+		}
+	}
+	if !ready {
+		status.Status = "not ready"
+	}
+	if expire := tlsManager.Expiration(); !expire.IsZero() {
+		status.CertExpire = &expire
+		status.CertExpireIn = int64(time.Until(expire).Seconds())
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	writeJSON(w, status)
+}
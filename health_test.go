@@ -0,0 +1,85 @@
+package main
+
+// health_test module provides unit tests of liveness and readiness probes
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestReadiness tests readiness of the server
+func TestReadiness(t *testing.T) {
+	defer func(maxAge int64) { Config.ReadyMaxAge = maxAge; wSources = nil }(Config.ReadyMaxAge)
+	fname := writeTestFile(t, t.TempDir(), "wmstats.json", []byte(`{"result":[]}`))
+	tests := []struct {
+		name    string
+		sources func() *WMStatsSources
+		maxAge  int64
+		mtime   time.Time
+		checked time.Duration // time since last successful refresh
+		ready   bool
+		reason  string
+	}{
+		{"not initialized", func() *WMStatsSources { return nil }, 0, time.Now(), 0, false, "not initialized"},
+		{"not loaded", func() *WMStatsSources {
+			s, _ := NewWMStatsSources([]Source{{"prod", fname + ".missing"}}, false)
+			s.update(context.Background())
+			return s
+		}, 0, time.Now(), 0, false, "not yet loaded"},
+		{"loaded", nil, 0, time.Now(), 0, true, ""},
+		// readiness does not depend on age of local files
+		{"old local file", nil, 600, time.Now().Add(-2 * time.Hour), 0, true, ""},
+		{"refreshed within max age", nil, 600, time.Now(), 5 * time.Minute, true, ""},
+		{"not refreshed within max age", nil, 600, time.Now(), time.Hour, false, "max age is 10m0s"},
+		// data which is not refreshed is ready if max age is not set
+		{"no max age", nil, 0, time.Now(), time.Hour, true, ""},
+	}
+	for _, tt := range tests {
+		Config.ReadyMaxAge = tt.maxAge
+		if err := os.Chtimes(fname, tt.mtime, tt.mtime); err != nil {
+			t.Fatal(err)
+		}
+		if tt.sources != nil {
+			wSources = tt.sources()
+		} else {
+			wSources, _ = NewWMStatsSources([]Source{{"prod", fname}}, false)
+			wSources.update(context.Background())
+			mgr := wSources.Managers["prod"]
+			mgr.mutex.Lock()
+			mgr.Checked = mgr.Checked.Add(-tt.checked)
+			mgr.mutex.Unlock()
+		}
+		ready, reasons := readiness()
+		if ready != tt.ready {
+			t.Errorf("%s: ready %v, expected %v, reasons %v", tt.name, ready, tt.ready, reasons)
+		}
+		if tt.reason != "" && (len(reasons) == 0 || !strings.Contains(reasons[0], tt.reason)) {
+			t.Errorf("%s: reasons %v, expected %q", tt.name, reasons, tt.reason)
+		}
+
+		// readiness probe reports service unavailability while liveness
+		// probe is always ok
+		w := httptest.NewRecorder()
+		ReadyHandler(w, httptest.NewRequest("GET", "/healthz/ready", nil))
+		code := http.StatusOK
+		if !tt.ready {
+			code = http.StatusServiceUnavailable
+		}
+		if w.Code != code {
+			t.Errorf("%s: readiness probe status %d, expected %d", tt.name, w.Code, code)
+		}
+		w = httptest.NewRecorder()
+		LiveHandler(w, httptest.NewRequest("GET", "/healthz/live", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: liveness probe status %d", tt.name, w.Code)
+		}
+	}
+}
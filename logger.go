@@ -0,0 +1,326 @@
+package main
+
+// logger module provides structured logging with levels and request IDs.
+// The logs are either plain text (default) or JSON records with the
+// following fields:
+//
+//	ts         time of the record in RFC3339 format (UTC, nanoseconds)
+//	level      debug, info, warn or error
+//	msg        log message
+//	service    name of the service, i.e. wmstats
+//	host       hostname of the server
+//	caller     file:line of the log call
+//	request_id ID of HTTP request or of the refresh cycle
+//	fields     additional fields of the record, e.g. status or duration
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// log levels
+const (
+	LevelDebug = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// LogLevels defines names of log levels
+var LogLevels = []string{"debug", "info", "warn", "error"}
+
+// LogFormat defines format of server logs: text or json
+var LogFormat = "text"
+
+// LogLevel defines minimal level of logged records
+var LogLevel = LevelInfo
+
+// RequestIDHeader defines HTTP header which carries request ID
+const RequestIDHeader = "X-Request-Id"
+
+// RedactedHeaders defines HTTP headers whose values are never logged,
+// headers which names contain token, secret, password or key are redacted too
+var RedactedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"Cms-Authn-Hmac",
+}
+
+// LogEntry represents structured (JSON) log record
+type LogEntry struct {
+	Time      string                 `json:"ts"`                   // time of the record
+	Level     string                 `json:"level"`                // log level
+	Message   string                 `json:"msg"`                  // log message
+	Service   string                 `json:"service"`              // name of the service
+	Host      string                 `json:"host"`                 // hostname of the server
+	Caller    string                 `json:"caller,omitempty"`     // file:line of the log call
+	RequestID string                 `json:"request_id,omitempty"` // ID of request or refresh cycle
+	Fields    map[string]interface{} `json:"fields,omitempty"`     // additional fields
+}
+
+// log output and its lock
+var (
+	logOutput io.Writer = os.Stderr
+	logLock   sync.Mutex
+	logHost   = hostName()
+)
+
+// helper function to parse name of log level
+func parseLogLevel(name string) (int, error) {
+	for i, l := range LogLevels {
+		if strings.ToLower(name) == l {
+			return i, nil
+		}
+	}
+	if strings.ToLower(name) == "warning" {
+		return LevelWarn, nil
+	}
+	return LevelInfo, fmt.Errorf("unsupported log level '%s', supported levels: %s", name, strings.Join(LogLevels, ", "))
+}
+
+// helper function to setup logging of the server with given format, level
+// and output. In JSON format records of standard logger are converted to
+// JSON records too.
+func setupLogging(format, level string, out io.Writer) error {
+	if level != "" {
+		l, err := parseLogLevel(level)
+		if err != nil {
+			return err
+		}
+		LogLevel = l
+	}
+	switch format {
+	case "", "text":
+		LogFormat = "text"
+		log.SetOutput(out)
+	case "json":
+		LogFormat = "json"
+		logOutput = out
+		log.SetFlags(log.Lshortfile)
+		log.SetOutput(jsonLogWriter{})
+	default:
+		return fmt.Errorf("unsupported log format '%s', supported formats: text, json", format)
+	}
+	return nil
+}
+
+// request ID context key
+type requestIDKey struct{}
+
+// helper function to generate new request ID
+func newRequestID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// pattern of request IDs accepted from clients
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// helper function to add given request ID to the context
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// helper function to get request ID of given context
+func requestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// helper function to check if HTTP header should be redacted
+func redactHeader(name string) bool {
+	for _, h := range RedactedHeaders {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	name = strings.ToLower(name)
+	for _, s := range []string{"token", "secret", "password", "key"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function to get copy of HTTP headers with redacted values of
+// sensitive headers, it is safe to log the returned headers
+func redactHeaders(header http.Header) map[string]string {
+	out := make(map[string]string)
+	for k, v := range header {
+		if redactHeader(k) {
+			out[k] = "[REDACTED]"
+			continue
+		}
+		out[k] = strings.Join(v, ", ")
+	}
+	return out
+}
+
+// helper function to convert field value to JSON friendly value
+func fieldValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case error:
+		return val.Error()
+	case time.Duration:
+		return val.Seconds()
+	case time.Time:
+		return val.UTC().Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return val.String()
+	}
+	return v
+}
+
+// helper function to write log record of given level, message and fields
+// given as key-value pairs, fields with empty string values are omitted.
+// The depth is number of stack frames to skip to find the caller of log
+// function.
+func logRecord(ctx context.Context, depth, level int, msg string, kv ...interface{}) {
+	if level < LogLevel {
+		return
+	}
+	fields := make(map[string]interface{})
+	var keys []string
+	for i := 0; i < len(kv); i += 2 {
+		key := fmt.Sprintf("%v", kv[i])
+		var val interface{}
+		if i+1 < len(kv) {
+			val = kv[i+1]
+		}
+		if v, ok := val.(string); ok && v == "" {
+			continue
+		}
+		if _, ok := fields[key]; !ok {
+			keys = append(keys, key)
+		}
+		fields[key] = fieldValue(val)
+	}
+	rid := requestID(ctx)
+	if LogFormat != "json" {
+		var out strings.Builder
+		switch level {
+		case LevelError:
+			out.WriteString("ERROR: ")
+		case LevelWarn:
+			out.WriteString("WARNING: ")
+		case LevelDebug:
+			out.WriteString("DEBUG: ")
+		}
+		out.WriteString(msg)
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&out, " %s=%v", k, fields[k])
+		}
+		if rid != "" {
+			fmt.Fprintf(&out, " request_id=%s", rid)
+		}
+		log.Output(depth+1, out.String())
+		return
+	}
+	entry := LogEntry{Level: LogLevels[level], Message: msg, RequestID: rid}
+	if _, file, line, ok := runtime.Caller(depth); ok {
+		entry.Caller = fmt.Sprintf("%s:%d", filepath.Base(file), line)
+	}
+	if len(fields) > 0 {
+		entry.Fields = fields
+	}
+	writeLogEntry(entry)
+}
+
+// helper function to write JSON log record
+func writeLogEntry(entry LogEntry) {
+	entry.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	entry.Service = "wmstats"
+	entry.Host = logHost
+	data, err := json.Marshal(entry)
+	if err != nil {
+		data, _ = json.Marshal(LogEntry{Time: entry.Time, Level: "error", Message: fmt.Sprintf("unable to marshal log record: %v", err)})
+	}
+	logLock.Lock()
+	defer logLock.Unlock()
+	logOutput.Write(append(data, '\n'))
+}
+
+// helper function to log debug message with given key-value pairs
+func logDebug(ctx context.Context, msg string, kv ...interface{}) {
+	logRecord(ctx, 2, LevelDebug, msg, kv...)
+}
+
+// helper function to log info message with given key-value pairs
+func logInfo(ctx context.Context, msg string, kv ...interface{}) {
+	logRecord(ctx, 2, LevelInfo, msg, kv...)
+}
+
+// helper function to log warning with given key-value pairs
+func logWarn(ctx context.Context, msg string, kv ...interface{}) {
+	logRecord(ctx, 2, LevelWarn, msg, kv...)
+}
+
+// helper function to log error with given key-value pairs
+func logError(ctx context.Context, msg string, kv ...interface{}) {
+	logRecord(ctx, 2, LevelError, msg, kv...)
+}
+
+// pattern of caller prefix of standard logger records
+var callerPattern = regexp.MustCompile(`^([\w.-]+\.go:\d+): `)
+
+// jsonLogWriter converts records of standard logger into JSON records,
+// the level of record is taken from ERROR/WARNING/DEBUG message prefix
+type jsonLogWriter struct{}
+
+// Write implements io.Writer interface
+func (w jsonLogWriter) Write(data []byte) (int, error) {
+	msg := string(bytes.TrimRight(data, "\n"))
+	entry := LogEntry{Level: LogLevels[LevelInfo]}
+	if m := callerPattern.FindStringSubmatch(msg); m != nil {
+		entry.Caller = m[1]
+		msg = msg[len(m[0]):]
+	}
+	level := LevelInfo
+	for _, p := range []struct {
+		Prefix string
+		Level  int
+	}{{"ERROR", LevelError}, {"WARNING", LevelWarn}, {"DEBUG", LevelDebug}} {
+		if strings.HasPrefix(msg, p.Prefix) {
+			level = p.Level
+			msg = strings.TrimLeft(msg[len(p.Prefix):], ": ")
+			break
+		}
+	}
+	if level < LogLevel {
+		return len(data), nil
+	}
+	entry.Level = LogLevels[level]
+	entry.Message = msg
+	writeLogEntry(entry)
+	return len(data), nil
+}
@@ -0,0 +1,86 @@
+package main
+
+// logger_test module provides unit tests of structured logging
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestRedactHeaders tests that values of sensitive HTTP headers are redacted
+func TestRedactHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    []string
+		redacted bool
+	}{
+		{"Authorization", []string{"Bearer secret-token"}, true},
+		{"authorization", []string{"Basic dXNlcjpwYXNz"}, true},
+		{"Proxy-Authorization", []string{"Basic dXNlcjpwYXNz"}, true},
+		{"Cookie", []string{"session=abc", "auth=xyz"}, true},
+		{"Set-Cookie", []string{"session=abc"}, true},
+		{"Cms-Authn-Hmac", []string{"deadbeef"}, true},
+		{"X-Auth-Token", []string{"abc"}, true},
+		{"X-Api-Key", []string{"abc"}, true},
+		{"X-Client-Secret", []string{"abc"}, true},
+		{"X-Db-Password", []string{"abc"}, true},
+		{"Accept", []string{"application/json"}, false},
+		{"User-Agent", []string{"curl/7.79.1"}, false},
+		{"X-Forwarded-For", []string{"10.0.0.1", "10.0.0.2"}, false},
+		{"Cms-Authn-Login", []string{"user"}, false},
+	}
+	header := make(http.Header)
+	expected := make(map[string]string)
+	for _, tt := range tests {
+		header[tt.name] = tt.value
+		expected[tt.name] = strings.Join(tt.value, ", ")
+		if tt.redacted {
+			expected[tt.name] = "[REDACTED]"
+		}
+		if redactHeader(tt.name) != tt.redacted {
+			t.Errorf("redactHeader(%s) = %v, expected %v", tt.name, !tt.redacted, tt.redacted)
+		}
+	}
+	out := redactHeaders(header)
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("got\n%v\nexpected\n%v", out, expected)
+	}
+	// original headers are not modified
+	if header.Get("Authorization") != "Bearer secret-token" {
+		t.Errorf("original headers are modified")
+	}
+}
+
+// TestLogRedactedHeaders tests that log records never contain values of
+// sensitive HTTP headers
+func TestLogRedactedHeaders(t *testing.T) {
+	defer func(format string, out io.Writer) { LogFormat, logOutput = format, out }(LogFormat, logOutput)
+	var buf bytes.Buffer
+	LogFormat, logOutput = "json", &buf
+	header := http.Header{
+		"Authorization":  {"Bearer secret-token"},
+		"Cms-Authn-Hmac": {"secret-hmac"},
+		"User-Agent":     {"wmstats-test"},
+	}
+	logError(withRequestID(context.Background(), "req1"), "fail to authenticate", "headers", redactHeaders(header))
+	if strings.Contains(buf.String(), "secret") {
+		t.Errorf("log record contains sensitive values %s", buf.String())
+	}
+	var entry LogEntry
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	headers, _ := entry.Fields["headers"].(map[string]interface{})
+	if entry.RequestID != "req1" || headers["User-Agent"] != "wmstats-test" || headers["Authorization"] != "[REDACTED]" {
+		t.Errorf("wrong log record %+v", entry)
+	}
+}
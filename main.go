@@ -8,7 +8,9 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
 	"runtime"
+	"strings"
 	"time"
 )
 
@@ -22,25 +24,47 @@ func info() string {
 }
 
 func main() {
+	// use sub-command if it is provided, e.g. wmstats summary file.json
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+
+	// legacy flags
 	var version bool
 	flag.BoolVar(&version, "version", false, "Show version")
 	var config string
 	flag.StringVar(&config, "config", "", "config file")
-	var wmstatsFile string
-	flag.StringVar(&wmstatsFile, "wmstatsFile", "", "wmstats file")
-	var filters string
-	flag.StringVar(&filters, "filters", "", "comma separated wmstats filters")
+	var opts CliOptions
+	opts.Register(flag.CommandLine)
 	var display string
-	flag.StringVar(&display, "display", "campaign", "display given attribute")
-	var verbose int
-	flag.IntVar(&verbose, "verbose", 0, "verbose level")
+	flag.StringVar(&display, "display", "campaign", "display given attribute: campaign, site, cmssw, agent or workflows")
+	var watch time.Duration
+	flag.DurationVar(&watch, "watch", 0, "re-read wmstats input on given interval and redraw the table, e.g. 1m")
+	var top int
+	flag.IntVar(&top, "top", 0, "show top N rows (alias to -limit)")
+	flag.Usage = func() {
+		commandsUsage()
+		fmt.Fprintln(os.Stderr, "\nLegacy options:")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 	if version {
 		fmt.Println("wmstats version:", info())
 		return
 	}
-	if wmstatsFile != "" {
-		cli(wmstatsFile, wmstatsFilters(filters), display, verbose)
+	if top > 0 {
+		opts.Limit = top
+	}
+	if (opts.File != "" || opts.Sources != "" || opts.Server != "") && watch > 0 {
+		if err := cliWatch(opts, display, watch); err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR:", err)
+			os.Exit(1)
+		}
+	} else if opts.File != "" || opts.Sources != "" || opts.Server != "" {
+		if err := cli(opts, display); err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR:", err)
+			os.Exit(1)
+		}
 	} else {
 		Server(config)
 	}
@@ -5,76 +5,29 @@ package main
 // Copyright (c) 2022 - Valentin Kuznetsov <vkuznet AT gmail dot com>
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"net/http"
 	"os"
-	"runtime"
+	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/load"
 	"github.com/shirou/gopsutil/mem"
-	"github.com/shirou/gopsutil/net"
 	"github.com/shirou/gopsutil/process"
 )
 
-// TotalGetRequests counts total number of GET requests received by the server
-var TotalGetRequests uint64
-
-// TotalPostRequests counts total number of POST requests received by the server
-var TotalPostRequests uint64
-
-// TotalPutRequests counts total number of PUT requests received by the server
-var TotalPutRequests uint64
+// MetricsInterval defines interval of background collection of system metrics
+var MetricsInterval = 30 * time.Second
 
 // MetricsLastUpdateTime keeps track of last update time of the metrics
 var MetricsLastUpdateTime time.Time
 
-// RPS represents requests per second for a given server
-var RPS float64
-
-// RPSPhysical represents requests per second for a given server times number of physical CPU cores
-var RPSPhysical float64
-
-// RPSLogical represents requests per second for a given server times number of logical CPU cores
-var RPSLogical float64
-
-// NumPhysicalCores represents number of cores in our node
-var NumPhysicalCores int
-
-// NumLogicalCores represents number of cores in our node
-var NumLogicalCores int
-
-// AvgGetRequestTime represents average GET request time
-var AvgGetRequestTime float64
-
-// AvgPostRequestTime represents average POST request time
-var AvgPostRequestTime float64
-
-// AvgPutRequestTime represents average PUT request time
-var AvgPutRequestTime float64
-
-// RequestStats holds metrics related to number of requests on a server
-type RequestStats struct {
-	TotalGetRequests  uint64
-	TotalPostRequests uint64
-	TotalPutRequests  uint64
-	Time              time.Time
-	NumPhysicalCores  int
-	NumLogicalCores   int
-}
-
-// Update RequestStatus metrics
-func (r *RequestStats) Update() {
-	r.TotalGetRequests = TotalGetRequests
-	r.TotalPostRequests = TotalPostRequests
-	r.TotalPutRequests = TotalPutRequests
-	r.NumPhysicalCores = NumPhysicalCores
-	r.NumLogicalCores = NumLogicalCores
-	r.Time = time.Now()
-}
-
-var rstat *RequestStats
-
 // Memory structure keeps track of server memory
 type Memory struct {
 	Total       uint64  `json:"total"`
@@ -89,297 +42,220 @@ type Mem struct {
 	Swap    Memory `json:"swap"`    // swap memory metrics from gopsutils
 }
 
-// Metrics provide various metrics about our server
+// Metrics provide various system metrics about our server, they are
+// collected in background since gopsutil calls are expensive
 type Metrics struct {
-	CPU                []float64               `json:"cpu"`                // cpu metrics from gopsutils
-	CpuPercent         float64                 `json:"cpu_pct"`            // cpu percent
-	Connections        []net.ConnectionStat    `json:"connections"`        // connections metrics from gopsutils
-	Load               load.AvgStat            `json:"load"`               // load metrics from gopsutils
-	Memory             Mem                     `json:"memory"`             // memory metrics from gopsutils
-	OpenFiles          []process.OpenFilesStat `json:"openFiles"`          // open files metrics from gopsutils
-	GoRoutines         uint64                  `json:"goroutines"`         // total number of go routines at run-time
-	Uptime             float64                 `json:"uptime"`             // uptime of the server
-	GetRequests        uint64                  `json:"getRequests"`        // total number of get requests across all services
-	PostRequests       uint64                  `json:"postRequests"`       // total number of post requests across all services
-	PutRequests        uint64                  `json:"putRequests"`        // total number of post requests across all services
-	AvgGetTime         float64                 `json:"avgGetTime"`         // avg GET request time
-	AvgPostTime        float64                 `json:"avgPostTime"`        // avg POST request time
-	AvgPutTime         float64                 `json:"avgPutTime"`         // avg PUT request time
-	RPS                float64                 `json:"rps"`                // throughput req/sec
-	RPSPhysical        float64                 `json:"rpsPhysical"`        // throughput req/sec using physical cpu
-	RPSLogical         float64                 `json:"rpsLogical"`         // throughput req/sec using logical cpu
-	ProcFS             ProcFS                  `json:"procfs"`             // metrics from prometheus procfs
-	MaxDBConnections   uint64                  `json:"maxDBConnections"`   // max number of DB connections
-	MaxIdleConnections uint64                  `json:"maxIdleConnections"` // max number of idle DB connections
+	CPU             []float64    `json:"cpu"`         // cpu metrics from gopsutils
+	CpuPercent      float64      `json:"cpu_pct"`     // cpu percent of the server process
+	TotalConnCount  uint64       `json:"connections"` // number of connections of the server process
+	EstConnCount    uint64       `json:"established"` // number of established connections
+	ListenConnCount uint64       `json:"listen"`      // number of listen connections
+	Load            load.AvgStat `json:"load"`        // load metrics from gopsutils
+	Memory          Mem          `json:"memory"`      // memory metrics from gopsutils
+	OpenFiles       int          `json:"openFiles"`   // number of open files of the server process
+	ProcFS          ProcFS       `json:"procfs"`      // metrics from prometheus procfs
 }
 
-func metrics() Metrics {
-	if rstat == nil {
-		rstat = &RequestStats{}
-		rstat.Time = time.Now()
-	}
-
-	// get cpu and mem profiles
-	m, _ := mem.VirtualMemory()
-	s, _ := mem.SwapMemory()
-	l, _ := load.Avg()
-	c, _ := cpu.Percent(time.Millisecond, true)
-	process, perr := process.NewProcess(int32(os.Getpid()))
+// system metrics and their lock
+var (
+	sysMetrics     Metrics
+	sysMetricsLock sync.RWMutex
+)
 
-	// get unfinished queries
-	metrics := Metrics{}
-	metrics.GoRoutines = uint64(runtime.NumGoroutine())
-	virt := Memory{Total: m.Total, Free: m.Free, Used: m.Used, UsedPercent: m.UsedPercent}
-	swap := Memory{Total: s.Total, Free: s.Free, Used: s.Used, UsedPercent: s.UsedPercent}
-	metrics.Memory = Mem{Virtual: virt, Swap: swap}
-	metrics.Load = *l
-	metrics.CPU = c
-	if perr == nil { // if we got process info
-		conn, err := process.Connections()
-		if err == nil {
-			metrics.Connections = conn
+// helper function to collect system metrics of given process
+func collectMetrics(proc *process.Process) Metrics {
+	var metrics Metrics
+	if m, err := mem.VirtualMemory(); err == nil {
+		metrics.Memory.Virtual = Memory{Total: m.Total, Free: m.Free, Used: m.Used, UsedPercent: m.UsedPercent}
+	}
+	if s, err := mem.SwapMemory(); err == nil {
+		metrics.Memory.Swap = Memory{Total: s.Total, Free: s.Free, Used: s.Used, UsedPercent: s.UsedPercent}
+	}
+	if l, err := load.Avg(); err == nil {
+		metrics.Load = *l
+	}
+	// zero interval compares cpu times with the ones of previous call
+	if c, err := cpu.Percent(0, true); err == nil {
+		metrics.CPU = c
+	}
+	if proc != nil {
+		if conn, err := proc.Connections(); err == nil {
+			metrics.TotalConnCount = uint64(len(conn))
+			for _, c := range conn {
+				switch c.Status {
+				case "ESTABLISHED":
+					metrics.EstConnCount++
+				case "LISTEN":
+					metrics.ListenConnCount++
+				}
+			}
 		}
-		openFiles, err := process.OpenFiles()
-		if err == nil {
-			metrics.OpenFiles = openFiles
+		if openFiles, err := proc.OpenFiles(); err == nil {
+			metrics.OpenFiles = len(openFiles)
+		}
+		if cpuPct, err := proc.Percent(0); err == nil {
+			metrics.CpuPercent = cpuPct
 		}
 	}
-
-	// get cpu percent
-	cpuPct, err := process.Percent(time.Duration(1 * time.Second))
-	if err == nil {
-		metrics.CpuPercent = cpuPct
-	}
-
 	metrics.ProcFS = ProcFSMetrics()
-	metrics.Uptime = time.Since(StartTime).Seconds()
-
-	metrics.AvgGetTime = AvgGetRequestTime
-	metrics.AvgPostTime = AvgPostRequestTime
-	metrics.AvgPutTime = AvgPutRequestTime
-
-	metrics.GetRequests = TotalGetRequests
-	metrics.PostRequests = TotalPostRequests
-	metrics.PutRequests = TotalPutRequests
-
-	lapse := time.Since(rstat.Time).Seconds()
-	total := float64(TotalGetRequests + TotalPostRequests + TotalPutRequests)
-	metrics.RPS = (total - float64(rstat.TotalGetRequests+rstat.TotalPostRequests+rstat.TotalPutRequests)) / lapse
-	metrics.RPSLogical = float64(rstat.NumLogicalCores-NumLogicalCores) / lapse
-	metrics.RPSPhysical = float64(rstat.NumPhysicalCores-NumPhysicalCores) / lapse
-
-	// update time stamp
-	MetricsLastUpdateTime = time.Now()
-
-	// update request stats metrics
-	rstat.Update()
-
 	return metrics
 }
 
-// helper function to generate metrics in prometheus format
-func promMetrics(prefix string) string {
-	var out string
-	data := metrics()
-
-	// cpu info
-	out += fmt.Sprintf("# HELP %s_cpu percentage of cpu used per CPU\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_cpu gauge\n", prefix)
-	for i, v := range data.CPU {
-		out += fmt.Sprintf("%s_cpu{core=\"%d\"} %v\n", prefix, i, v)
+// helper function to run as go-routine to collect system metrics on given interval
+func updateMetrics(ctx context.Context, interval time.Duration) {
+	proc, err := process.NewProcess(int32(os.Getpid()))
+	if err != nil {
+		log.Println("ERROR: unable to get process info", err)
+		proc = nil
 	}
-
-	// connections
-	var totCon, estCon, lisCon uint64
-	for _, c := range data.Connections {
-		v := c.Status
-		switch v {
-		case "ESTABLISHED":
-			estCon++
-		case "LISTEN":
-			lisCon++
+	for {
+		metrics := collectMetrics(proc)
+		sysMetricsLock.Lock()
+		sysMetrics = metrics
+		MetricsLastUpdateTime = time.Now()
+		sysMetricsLock.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
 		}
 	}
-	totCon = uint64(len(data.Connections))
-	out += fmt.Sprintf("# HELP %s_total_connections\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_total_connections gauge\n", prefix)
-	out += fmt.Sprintf("%s_total_connections %v\n", prefix, totCon)
-	out += fmt.Sprintf("# HELP %s_established_connections\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_established_connections gauge\n", prefix)
-	out += fmt.Sprintf("%s_established_connections %v\n", prefix, estCon)
-	out += fmt.Sprintf("# HELP %s_listen_connections\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_listen_connections gauge\n", prefix)
-	out += fmt.Sprintf("%s_listen_connections %v\n", prefix, lisCon)
-
-	// procfs metrics
-	// cpuTotal, vsize, rss, openFDs, maxFDs, maxVsize
-	out += fmt.Sprintf("# HELP %s_procfs_cputotal\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_procfs_cputotal gauge\n", prefix)
-	out += fmt.Sprintf("%s_procfs_cputotal %v\n", prefix, data.ProcFS.CpuTotal)
-	out += fmt.Sprintf("# HELP %s_procfs_vsize\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_procfs_vsize gauge\n", prefix)
-	out += fmt.Sprintf("%s_procfs_vsize %v\n", prefix, data.ProcFS.Vsize)
-	out += fmt.Sprintf("# HELP %s_procfs_rss\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_procfs_rss gauge\n", prefix)
-	out += fmt.Sprintf("%s_procfs_rss %v\n", prefix, data.ProcFS.Rss)
-	out += fmt.Sprintf("# HELP %s_procfs_openfds\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_procfs_openfds gauge\n", prefix)
-	out += fmt.Sprintf("%s_procfs_openfds %v\n", prefix, data.ProcFS.OpenFDs)
-	out += fmt.Sprintf("# HELP %s_procfs_maxfds\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_procfs_maxfds gauge\n", prefix)
-	out += fmt.Sprintf("%s_procfs_maxfds %v\n", prefix, data.ProcFS.MaxFDs)
-	out += fmt.Sprintf("# HELP %s_procfs_maxvsize\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_procfs_maxvsize gauge\n", prefix)
-	out += fmt.Sprintf("%s_procfs_maxvsize %v\n", prefix, data.ProcFS.MaxVsize)
-
-	// procfs /proc/stat metrics
-	out += fmt.Sprintf("# HELP %s_procfs_sumusercpus\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_procfs_sumusercpus gauge\n", prefix)
-	out += fmt.Sprintf("%s_procfs_sumusercpus %v\n", prefix, data.ProcFS.SumUserCPUs)
-	out += fmt.Sprintf("# HELP %s_procfs_sumsystemcpus\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_procfs_sumsystemcpus gauge\n", prefix)
-	out += fmt.Sprintf("%s_procfs_sumsystemcpus %v\n", prefix, data.ProcFS.SumSystemCPUs)
-
-	// cpu percent
-	out += fmt.Sprintf("# HELP %s_cpu_pct\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_cpu_pct gauge\n", prefix)
-	out += fmt.Sprintf("%s_cpu_pct %v\n", prefix, data.CpuPercent)
-
-	// load
-	out += fmt.Sprintf("# HELP %s_load1\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_load1 gauge\n", prefix)
-	out += fmt.Sprintf("%s_load1 %v\n", prefix, data.Load.Load1)
-	out += fmt.Sprintf("# HELP %s_load5\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_load5 gauge\n", prefix)
-	out += fmt.Sprintf("%s_load5 %v\n", prefix, data.Load.Load5)
-	out += fmt.Sprintf("# HELP %s_load15\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_load15 gauge\n", prefix)
-	out += fmt.Sprintf("%s_load15 %v\n", prefix, data.Load.Load15)
-
-	// memory virtual
-	out += fmt.Sprintf("# HELP %s_mem_virt_total reports total virtual memory in bytes\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_mem_virt_total gauge\n", prefix)
-	out += fmt.Sprintf("%s_mem_virt_total %v\n", prefix, data.Memory.Virtual.Total)
-	out += fmt.Sprintf("# HELP %s_mem_virt_free reports free virtual memory in bytes\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_mem_virt_free gauge\n", prefix)
-	out += fmt.Sprintf("%s_mem_virt_free %v\n", prefix, data.Memory.Virtual.Free)
-	out += fmt.Sprintf("# HELP %s_mem_virt_used reports used virtual memory in bytes\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_mem_virt_used gauge\n", prefix)
-	out += fmt.Sprintf("%s_mem_virt_used %v\n", prefix, data.Memory.Virtual.Used)
-	out += fmt.Sprintf("# HELP %s_mem_virt_pct reports percentage of virtual memory\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_mem_virt_pct gauge\n", prefix)
-	out += fmt.Sprintf("%s_mem_virt_pct %v\n", prefix, data.Memory.Virtual.UsedPercent)
-
-	// memory swap
-	out += fmt.Sprintf("# HELP %s_mem_swap_total reports total swap memory in bytes\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_mem_swap_total gauge\n", prefix)
-	out += fmt.Sprintf("%s_mem_swap_total %v\n", prefix, data.Memory.Swap.Total)
-	out += fmt.Sprintf("# HELP %s_mem_swap_free reports free swap memory in bytes\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_mem_swap_free gauge\n", prefix)
-	out += fmt.Sprintf("%s_mem_swap_free %v\n", prefix, data.Memory.Swap.Free)
-	out += fmt.Sprintf("# HELP %s_mem_swap_used reports used swap memory in bytes\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_mem_swap_used gauge\n", prefix)
-	out += fmt.Sprintf("%s_mem_swap_used %v\n", prefix, data.Memory.Swap.Used)
-	out += fmt.Sprintf("# HELP %s_mem_swap_pct reports percentage swap memory\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_mem_swap_pct gauge\n", prefix)
-	out += fmt.Sprintf("%s_mem_swap_pct %v\n", prefix, data.Memory.Swap.UsedPercent)
-
-	// open files
-	out += fmt.Sprintf("# HELP %s_open_files reports total number of open file descriptors\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_open_files gauge\n", prefix)
-	out += fmt.Sprintf("%s_open_files %v\n", prefix, len(data.OpenFiles))
-
-	// go routines
-	out += fmt.Sprintf("# HELP %s_goroutines reports total number of go routines\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_goroutines counter\n", prefix)
-	out += fmt.Sprintf("%s_goroutines %v\n", prefix, data.GoRoutines)
-
-	// uptime
-	out += fmt.Sprintf("# HELP %s_uptime reports server uptime in seconds\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_uptime counter\n", prefix)
-	out += fmt.Sprintf("%s_uptime %v\n", prefix, data.Uptime)
-
-	// total requests
-	out += fmt.Sprintf("# HELP %s_get_requests reports total number of HTTP GET requests\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_get_requests counter\n", prefix)
-	out += fmt.Sprintf("%s_get_requests %v\n", prefix, data.GetRequests)
-	out += fmt.Sprintf("# HELP %s_post_requests reports total number of HTTP POST requests\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_post_requests counter\n", prefix)
-	out += fmt.Sprintf("%s_post_requests %v\n", prefix, data.PostRequests)
-	out += fmt.Sprintf("# HELP %s_put_requests reports total number of HTTP POST requests\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_put_requests counter\n", prefix)
-	out += fmt.Sprintf("%s_put_requests %v\n", prefix, data.PutRequests)
-
-	// throughput, rps, rps physical cpu, rps logical cpu
-	out += fmt.Sprintf("# HELP %s_rps reports request per second average\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_rps gauge\n", prefix)
-	out += fmt.Sprintf("%s_rps %v\n", prefix, data.RPS)
-
-	out += fmt.Sprintf("# HELP %s_avg_get_time reports average get request time\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_avg_get_time gauge\n", prefix)
-	out += fmt.Sprintf("%s_avg_get_time %v\n", prefix, data.AvgGetTime)
-
-	out += fmt.Sprintf("# HELP %s_avg_post_time reports average post request time\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_avg_post_time gauge\n", prefix)
-	out += fmt.Sprintf("%s_avg_post_time %v\n", prefix, data.AvgPostTime)
-
-	out += fmt.Sprintf("# HELP %s_avg_put_time reports average put request time\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_avg_put_time gauge\n", prefix)
-	out += fmt.Sprintf("%s_avg_put_time %v\n", prefix, data.AvgPutTime)
-
-	out += fmt.Sprintf("# HELP %s_avg_get_time reports average get request time\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_avg_get_time gauge\n", prefix)
-	out += fmt.Sprintf("%s_avg_get_time %v\n", prefix, data.AvgGetTime)
+}
 
-	out += fmt.Sprintf("# HELP %s_rps_physical_cpu reports request per second average weighted by physical CPU cores\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_rps_physical_cpu gauge\n", prefix)
-	out += fmt.Sprintf("%s_rps_physical_cpu %v\n", prefix, data.RPSPhysical)
+// HTTP request metrics
+var (
+	httpRequestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "number of HTTP requests currently served",
+	})
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "duration of HTTP requests per route and status code",
+		Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}, []string{"method", "route", "code"})
+	httpResponseSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_response_size_bytes",
+		Help:    "size of HTTP responses per route and status code",
+		Buckets: prometheus.ExponentialBuckets(100, 10, 6),
+	}, []string{"method", "route", "code"})
+)
 
-	out += fmt.Sprintf("# HELP %s_rps_logical_cpu reports request per second average weighted by logical CPU cures\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_rps_logical_cpu gauge\n", prefix)
-	out += fmt.Sprintf("%s_rps_logical_cpu %v\n", prefix, data.RPSLogical)
+// helper function to record HTTP request of given method, route, status
+// code, duration (in seconds) and response size (in bytes)
+func recordRequest(method, route string, code int, duration float64, size int) {
+	status := fmt.Sprintf("%d", code)
+	httpRequestDuration.WithLabelValues(method, route, status).Observe(duration)
+	httpResponseSize.WithLabelValues(method, route, status).Observe(float64(size))
+}
 
-	// database metrics
-	out += fmt.Sprintf("# HELP %s_max_db_connections reports max number of DB conenctions\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_max_db_connections gauge\n", prefix)
-	out += fmt.Sprintf("%s_max_db_connections %v\n", prefix, data.MaxDBConnections)
+// systemCollector exposes system metrics collected in background
+type systemCollector struct {
+	cpu        *prometheus.Desc
+	cpuProcess *prometheus.Desc
+	conns      *prometheus.Desc
+	load       *prometheus.Desc
+	memory     *prometheus.Desc
+	memoryPct  *prometheus.Desc
+	openFiles  *prometheus.Desc
+	nodeCPU    *prometheus.Desc
+	uptime     *prometheus.Desc
+	updated    *prometheus.Desc
+}
 
-	out += fmt.Sprintf("# HELP %s_max_idle_connections reports max number of idls DB connections\n", prefix)
-	out += fmt.Sprintf("# TYPE %s_max_idle_connections gauge\n", prefix)
-	out += fmt.Sprintf("%s_max_idle_connections %v\n", prefix, data.MaxIdleConnections)
+// helper function to create system metrics collector
+func newSystemCollector() *systemCollector {
+	return &systemCollector{
+		cpu:        prometheus.NewDesc("cpu_usage_percent", "percentage of CPU used per core", []string{"core"}, nil),
+		cpuProcess: prometheus.NewDesc("process_cpu_usage_percent", "percentage of CPU used by the server process", nil, nil),
+		conns:      prometheus.NewDesc("connections", "number of connections of the server process per state", []string{"state"}, nil),
+		load:       prometheus.NewDesc("load_average", "system load average per interval", []string{"interval"}, nil),
+		memory:     prometheus.NewDesc("memory_bytes", "system memory in bytes per type and kind", []string{"type", "kind"}, nil),
+		memoryPct:  prometheus.NewDesc("memory_used_percent", "percentage of used system memory per type", []string{"type"}, nil),
+		openFiles:  prometheus.NewDesc("open_files", "number of files opened by the server process", nil, nil),
+		nodeCPU:    prometheus.NewDesc("node_cpu_seconds_total", "total CPU time of the node in seconds per mode", []string{"mode"}, nil),
+		uptime:     prometheus.NewDesc("uptime_seconds", "uptime of the server in seconds", nil, nil),
+		updated:    prometheus.NewDesc("metrics_last_update_timestamp_seconds", "unix time of last collection of system metrics", nil, nil),
+	}
+}
 
-	return out
+// Describe implements prometheus.Collector interface
+func (c *systemCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{c.cpu, c.cpuProcess, c.conns, c.load, c.memory, c.memoryPct, c.openFiles, c.nodeCPU, c.uptime, c.updated} {
+		ch <- d
+	}
 }
 
-// helper function to update RPS values
-func updateRPS() {
-	total := float64(TotalGetRequests + TotalPostRequests + TotalPutRequests)
-	oldLogical := float64(NumLogicalCores)
-	oldPhysical := float64(NumPhysicalCores)
-	time.Sleep(1 * time.Minute)
-	for {
-		RPS = (float64(TotalGetRequests+TotalPostRequests+TotalPutRequests) - total) / 3600
-		RPSLogical = (float64(NumLogicalCores) - oldLogical) / 3600.
-		RPSPhysical = (float64(NumPhysicalCores) - oldPhysical) / 3600.
+// Collect implements prometheus.Collector interface
+func (c *systemCollector) Collect(ch chan<- prometheus.Metric) {
+	sysMetricsLock.RLock()
+	data := sysMetrics
+	updated := MetricsLastUpdateTime
+	sysMetricsLock.RUnlock()
 
-		total = float64(TotalGetRequests + TotalPostRequests + TotalPutRequests)
-		oldLogical = float64(NumLogicalCores)
-		oldPhysical = float64(NumPhysicalCores)
-		time.Sleep(1 * time.Minute)
+	ch <- prometheus.MustNewConstMetric(c.uptime, prometheus.GaugeValue, time.Since(StartTime).Seconds())
+	if updated.IsZero() {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.updated, prometheus.GaugeValue, float64(updated.Unix()))
+	for i, v := range data.CPU {
+		ch <- prometheus.MustNewConstMetric(c.cpu, prometheus.GaugeValue, v, fmt.Sprintf("%d", i))
+	}
+	ch <- prometheus.MustNewConstMetric(c.cpuProcess, prometheus.GaugeValue, data.CpuPercent)
+	ch <- prometheus.MustNewConstMetric(c.conns, prometheus.GaugeValue, float64(data.TotalConnCount), "total")
+	ch <- prometheus.MustNewConstMetric(c.conns, prometheus.GaugeValue, float64(data.EstConnCount), "established")
+	ch <- prometheus.MustNewConstMetric(c.conns, prometheus.GaugeValue, float64(data.ListenConnCount), "listen")
+	ch <- prometheus.MustNewConstMetric(c.load, prometheus.GaugeValue, data.Load.Load1, "1m")
+	ch <- prometheus.MustNewConstMetric(c.load, prometheus.GaugeValue, data.Load.Load5, "5m")
+	ch <- prometheus.MustNewConstMetric(c.load, prometheus.GaugeValue, data.Load.Load15, "15m")
+	for name, m := range map[string]Memory{"virtual": data.Memory.Virtual, "swap": data.Memory.Swap} {
+		ch <- prometheus.MustNewConstMetric(c.memory, prometheus.GaugeValue, float64(m.Total), name, "total")
+		ch <- prometheus.MustNewConstMetric(c.memory, prometheus.GaugeValue, float64(m.Free), name, "free")
+		ch <- prometheus.MustNewConstMetric(c.memory, prometheus.GaugeValue, float64(m.Used), name, "used")
+		ch <- prometheus.MustNewConstMetric(c.memoryPct, prometheus.GaugeValue, m.UsedPercent, name)
 	}
+	ch <- prometheus.MustNewConstMetric(c.openFiles, prometheus.GaugeValue, float64(data.OpenFiles))
+	ch <- prometheus.MustNewConstMetric(c.nodeCPU, prometheus.CounterValue, data.ProcFS.SumUserCPUs, "user")
+	ch <- prometheus.MustNewConstMetric(c.nodeCPU, prometheus.CounterValue, data.ProcFS.SumSystemCPUs, "system")
 }
 
-// helper function to update avg get request time
-func updateGetRequestTime(time0 time.Time) {
-	AvgGetRequestTime += time.Since(time0).Seconds() / float64(TotalGetRequests)
+// promRegistry represents registry of server metrics
+var promRegistry *prometheus.Registry
+
+// NewMetricsRegistry creates registry of server metrics, server metrics
+// names are prefixed by given prefix while standard Go runtime and process
+// metrics keep their conventional names
+func NewMetricsRegistry(prefix string) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector())
+	registry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	reg := prometheus.WrapRegistererWithPrefix(prefix+"_", registry)
+	reg.MustRegister(
+		newSystemCollector(),
+		httpRequestsInFlight,
+		httpRequestDuration,
+		httpResponseSize,
+		newFetchCollector(),
+		newStatsCollector(),
+	)
+	return registry
 }
 
-// helper function to update avg post request time
-func updatePostRequestTime(time0 time.Time) {
-	AvgPostRequestTime += time.Since(time0).Seconds() / float64(TotalPostRequests)
+// promHandler represents HTTP handler of server metrics
+var promHandler http.Handler
+
+// helper function to initialize server metrics with given metrics prefix
+func initMetrics(prefix string) {
+	promRegistry = NewMetricsRegistry(prefix)
+	opts := promhttp.HandlerOpts{EnableOpenMetrics: true, ErrorLog: log.Default()}
+	promHandler = promhttp.HandlerFor(promRegistry, opts)
 }
 
-// helper function to update avg put request time
-func updatePutRequestTime(time0 time.Time) {
-	AvgPutRequestTime += time.Since(time0).Seconds() / float64(TotalPutRequests)
+// MetricsHandler provides metrics either in prometheus text format or in
+// OpenMetrics format depending on Accept header of the request
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if promHandler == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	promHandler.ServeHTTP(w, r)
 }
@@ -0,0 +1,230 @@
+package main
+
+// metrics_test module provides unit tests of server metrics
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// helper function to get number of HTTP requests of given route and status code
+func requestCount(t *testing.T, method, route string, code int) uint64 {
+	var m dto.Metric
+	observer := httpRequestDuration.WithLabelValues(method, route, fmt.Sprintf("%d", code))
+	if err := observer.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+// helper function to get number of HTTP requests in flight
+func requestsInFlight(t *testing.T) float64 {
+	var m dto.Metric
+	if err := httpRequestsInFlight.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+// TestMetricsMiddleware tests that HTTP requests are accounted per route
+// template and status code
+func TestMetricsMiddleware(t *testing.T) {
+	tmpls, err := NewTemplates(templatesFS(staticFS()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(tmpls *Templates) { _templates = tmpls }(_templates)
+	_templates = tmpls
+	initLimiter("100-S")
+
+	tests := []struct {
+		method string
+		url    string
+		route  string
+		code   int
+		count  uint64 // number of requests
+	}{
+		{"GET", "/healthz/live", "/healthz/live", http.StatusOK, 2},
+		// readiness probe fails since wmstats sources are not initialized
+		{"GET", "/healthz/ready", "/healthz/ready", http.StatusServiceUnavailable, 1},
+		// requests which do not match any route are accounted together
+		{"GET", "/missing", "other", http.StatusNotFound, 3},
+		{"GET", "/search/missing", "other", http.StatusNotFound, 1},
+		{"POST", "/healthz/live", "other", http.StatusMethodNotAllowed, 1},
+	}
+	router := Handlers()
+	for _, tt := range tests {
+		count := requestCount(t, tt.method, tt.route, tt.code)
+		for i := uint64(0); i < tt.count; i++ {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.url, nil))
+			if w.Code != tt.code {
+				t.Errorf("%s %s: status %d, expected %d", tt.method, tt.url, w.Code, tt.code)
+			}
+		}
+		if n := requestCount(t, tt.method, tt.route, tt.code) - count; n != tt.count {
+			t.Errorf("%s %s: %d requests of route %s, expected %d", tt.method, tt.url, n, tt.route, tt.count)
+		}
+	}
+	if n := requestsInFlight(t); n != 0 {
+		t.Errorf("%v requests in flight after all requests are served", n)
+	}
+}
+
+// TestMetricsInFlight tests accounting of HTTP requests in flight and
+// of routes with variables
+func TestMetricsInFlight(t *testing.T) {
+	started, release := make(chan bool), make(chan bool)
+	router := mux.NewRouter()
+	router.HandleFunc("/block/{id}", func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-release
+		w.WriteHeader(http.StatusAccepted)
+	})
+	router.Use(metricsMiddleware)
+
+	count := requestCount(t, "GET", "/block/{id}", http.StatusAccepted)
+	inFlight := requestsInFlight(t)
+	done := make(chan bool)
+	for i := 0; i < 2; i++ {
+		go func(i int) {
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", fmt.Sprintf("/block/%d", i), nil))
+			done <- true
+		}(i)
+		<-started
+	}
+	if n := requestsInFlight(t) - inFlight; n != 2 {
+		t.Errorf("%v requests in flight, expected 2", n)
+	}
+	close(release)
+	<-done
+	<-done
+	if n := requestsInFlight(t) - inFlight; n != 0 {
+		t.Errorf("%v requests in flight after requests are served", n)
+	}
+	// requests are accounted by route template rather than by path
+	if n := requestCount(t, "GET", "/block/{id}", http.StatusAccepted) - count; n != 2 {
+		t.Errorf("%d requests of route with variables, expected 2", n)
+	}
+}
+
+// helper function to get types of metrics of given exposition keyed by
+// metric name, duplicate names of server metrics are reported as errors.
+// Go runtime metrics are not checked since in OpenMetrics format their
+// go_memstats_alloc_bytes gauge and counter share the same name.
+func metricTypes(t *testing.T, exposition string) map[string]string {
+	types := make(map[string]string)
+	for _, line := range strings.Split(exposition, "\n") {
+		if !strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			t.Errorf("wrong TYPE line %q", line)
+			continue
+		}
+		if _, ok := types[fields[2]]; ok && strings.HasPrefix(fields[2], "wmstats_") {
+			t.Errorf("duplicate metric %s", fields[2])
+		}
+		types[fields[2]] = fields[3]
+	}
+	return types
+}
+
+// TestMetricsHandler tests exposition of server metrics in prometheus text
+// and OpenMetrics formats
+func TestMetricsHandler(t *testing.T) {
+	defer func(registry *prometheus.Registry, handler http.Handler) {
+		promRegistry, promHandler = registry, handler
+	}(promRegistry, promHandler)
+	sources := testSources(t)
+	sources.update(context.Background())
+	wSources = sources
+	_wmstatsInfoLock.Lock()
+	_wmstatsInfo["prod"] = wmstatsInfoCache{Info: &WMStatsInfo{
+		SiteStatsMap:  SiteStatsMap{"T1_US_FNAL": {Requests: 2, FailureRate: 12.5}},
+		AgentStatsMap: AgentStatsMap{"vocms0250": {Requests: 3, FailureRate: 40}},
+		CMSSWStatsMap: CMSSWStatsMap{"CMSSW_12_4_0": {Requests: 1, FailureRate: 7}},
+	}}
+	_wmstatsInfoLock.Unlock()
+	defer func() {
+		wSources = nil
+		_wmstatsInfoLock.Lock()
+		delete(_wmstatsInfo, "prod")
+		_wmstatsInfoLock.Unlock()
+	}()
+	initMetrics("wmstats")
+	recordRequest("GET", "/healthz", http.StatusOK, 0.01, 2)
+
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+		types       map[string]string
+	}{
+		{"text", "", "text/plain; version=0.0.4", map[string]string{
+			"wmstats_http_request_duration_seconds": "histogram",
+			"wmstats_http_requests_in_flight":       "gauge",
+			"wmstats_fetch_retries_total":           "counter",
+			"wmstats_refresh_failures_total":        "counter",
+			"wmstats_source_up":                     "gauge",
+			"wmstats_site_failure_rate":             "gauge",
+			"wmstats_site_failed_jobs":              "gauge",
+			"wmstats_agent_failed_jobs":             "gauge",
+			"wmstats_cmssw_failed_jobs":             "gauge",
+			// standard Go runtime metrics are not prefixed
+			"go_goroutines": "gauge",
+		}},
+		// counters are exposed without _total suffix in their metadata
+		{"openmetrics", "application/openmetrics-text", "application/openmetrics-text", map[string]string{
+			"wmstats_http_request_duration_seconds": "histogram",
+			"wmstats_http_requests_in_flight":       "gauge",
+			"wmstats_fetch_retries":                 "counter",
+			"wmstats_refresh_failures":              "counter",
+			"wmstats_source_up":                     "gauge",
+			"wmstats_agent_failed_jobs":             "gauge",
+			"go_goroutines":                         "gauge",
+		}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		MetricsHandler(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", tt.name, w.Code, w.Body.String())
+		}
+		if ctype := w.Header().Get("Content-Type"); !strings.HasPrefix(ctype, tt.contentType) {
+			t.Errorf("%s: content type %q, expected %q", tt.name, ctype, tt.contentType)
+		}
+		body := w.Body.String()
+		types := metricTypes(t, body)
+		for name, mtype := range tt.types {
+			if types[name] != mtype {
+				t.Errorf("%s: metric %s of type %q, expected %q", tt.name, name, types[name], mtype)
+			}
+		}
+		for name := range types {
+			if strings.Contains(name, "_failure_rate") && !strings.HasPrefix(name, "wmstats_site_") &&
+				!strings.HasPrefix(name, "wmstats_campaign_") {
+				t.Errorf("%s: number of failed jobs is exposed as %s", tt.name, name)
+			}
+		}
+		if eof := strings.HasSuffix(body, "# EOF\n"); eof != (tt.name == "openmetrics") {
+			t.Errorf("%s: wrong end of exposition %q", tt.name, body[len(body)-10:])
+		}
+	}
+}
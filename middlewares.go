@@ -2,7 +2,14 @@ package main
 
 import (
 	"log"
+	"net"
 	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 
 	limiter "github.com/ulule/limiter/v3"
 	stdlib "github.com/ulule/limiter/v3/drivers/middleware/stdlib"
@@ -35,35 +42,168 @@ func initLimiter(period string) {
 // helper to auth/authz incoming requests to the server
 func authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// liveness and readiness probes do not carry CMS auth headers
+		if r.URL.Path == basePath("/healthz/live") || r.URL.Path == basePath("/healthz/ready") {
+			next.ServeHTTP(w, r)
+			return
+		}
 		// perform authentication
+		_, span := startSpan(r.Context(), "auth")
 		status := CMSAuth.CheckAuthnAuthz(r.Header)
+		span.SetAttributes(attribute.Bool("wmstats.auth.authenticated", status))
 		if !status {
-			log.Printf("ERROR: fail to authenticate, HTTP headers %+v\n", r.Header)
+			span.SetStatus(codes.Error, "fail to authenticate")
+			logError(r.Context(), "fail to authenticate", "path", r.URL.Path, "headers", redactHeaders(r.Header))
 			w.WriteHeader(http.StatusForbidden)
+			span.End()
 			return
 		}
-		if Config.Verbose > 2 {
-			log.Printf("Auth layer status: %v headers: %+v\n", status, r.Header)
-		}
+		logDebug(r.Context(), "auth layer status", "status", status, "headers", redactHeaders(r.Header))
 
 		// check if user has proper roles to DBS (non GET) APIs
 		if r.Method != "GET" && Config.CMSRole != "" && Config.CMSGroup != "" {
 			status = CMSAuth.CheckCMSAuthz(r.Header, Config.CMSRole, Config.CMSGroup, "")
+			span.SetAttributes(attribute.Bool("wmstats.auth.authorized", status))
 			if !status {
-				log.Printf("ERROR: fail to authorize used with role=%v and group=%v, HTTP headers %+v\n", Config.CMSRole, Config.CMSGroup, r.Header)
+				span.SetStatus(codes.Error, "fail to authorize")
+				logError(r.Context(), "fail to authorize user", "role", Config.CMSRole, "group", Config.CMSGroup, "headers", redactHeaders(r.Header))
 				w.WriteHeader(http.StatusUnauthorized)
+				span.End()
 				return
 			}
 		}
 
 		// Call the next handler
+		span.End()
 		next.ServeHTTP(w, r)
 	})
 }
 
 // limit middleware limits incoming requests
 func limitMiddleware(next http.Handler) http.Handler {
-	return LimiterMiddleware.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		next.ServeHTTP(w, r)
-	}))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := startSpan(r.Context(), "limiter")
+		limited := true
+		LimiterMiddleware.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limited = false
+			span.End()
+			next.ServeHTTP(w, r)
+		})).ServeHTTP(w, r)
+		if limited {
+			span.SetAttributes(attribute.Bool("wmstats.limiter.limited", true))
+			span.End()
+		}
+	})
+}
+
+// statusWriter wraps http.ResponseWriter to capture status code and size of response
+type statusWriter struct {
+	http.ResponseWriter
+	code int // HTTP status code
+	size int // number of written bytes
+}
+
+// WriteHeader implements http.ResponseWriter interface
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements http.ResponseWriter interface
+func (w *statusWriter) Write(data []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.size += n
+	return n, err
+}
+
+// Flush implements http.Flusher interface
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// request ID middleware assigns ID to incoming request, the ID provided by
+// client (or proxy) in X-Request-Id header is used if it is valid. The ID is
+// returned in response header and it is propagated to handlers via request
+// context.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(withRequestID(r.Context(), id)))
+	})
+}
+
+// access log middleware writes structured log record of every request
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time0 := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.code == 0 {
+			sw.code = http.StatusOK
+		}
+		clientIP := r.RemoteAddr
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			clientIP = strings.TrimSpace(strings.Split(xff, ",")[0])
+		} else if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			clientIP = host
+		}
+		level := LevelInfo
+		if sw.code >= http.StatusInternalServerError {
+			level = LevelError
+		}
+		logRecord(r.Context(), 1, level, "request",
+			"method", r.Method,
+			"uri", r.RequestURI,
+			"route", routeTemplate(r),
+			"proto", r.Proto,
+			"status", sw.code,
+			"bytes_in", r.ContentLength,
+			"bytes_out", sw.size,
+			"duration", time.Since(time0),
+			"client_ip", clientIP,
+			"user_agent", r.UserAgent(),
+			"referer", r.Referer(),
+			"login", r.Header.Get("Cms-Authn-Login"))
+	})
+}
+
+// helper function to get route template of request, it returns "other"
+// for requests which do not match any route
+func routeTemplate(r *http.Request) string {
+	if rt := mux.CurrentRoute(r); rt != nil {
+		if tmpl, err := rt.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	return "other"
+}
+
+// metrics middleware accounts incoming requests, i.e. number of requests,
+// their latencies, in-flight requests and response sizes
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time0 := time.Now()
+		httpRequestsInFlight.Inc()
+		defer httpRequestsInFlight.Dec()
+
+		// use route template to keep number of metrics series bounded
+		route := routeTemplate(r)
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.code == 0 {
+			sw.code = http.StatusOK
+		}
+		recordRequest(r.Method, route, sw.code, time.Since(time0).Seconds(), sw.size)
+	})
 }
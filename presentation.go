@@ -5,332 +5,315 @@ package main
 // Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
 //
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
 
 // WMStatsMap defines interface to represent different WMStats maps
 type WMStatsMap interface {
-	HTMLTable() string // create proper html table for our map
+	Table() *Table // create generic table for our map
 }
 
-// SiteStatsMap
-type SiteStatsMap map[string]SiteStats
-
-// HTMLTable implements WMStatsMap interface
-func (wmap SiteStatsMap) HTMLTable() string {
-	t := `<table class="is-striped is-bordered" id="site-stats"><tr>`
-	t += `<th onclick="sortTable('site-stats', 0)">Site</th>`
-	t += `<th onclick="sortTable('site-stats', 1)">Requests</th>`
-	t += `<th onclick="sortTable('site-stats', 2)">Pending</th>`
-	t += `<th onclick="sortTable('site-stats', 3)">Running</th>`
-	t += `<th onclick="sortTable('site-stats', 4)">CoolOff</th>`
-	t += `<th onclick="sortTable('site-stats', 5)">Failure Rate</th>`
-	t += "</tr>\n"
-	for key, data := range wmap {
-		t += "<tr>"
-		t += fmt.Sprintf("<td>%v</td>", key)
-		t += fmt.Sprintf("<td>%v</td>", data.Requests)
-		t += fmt.Sprintf("<td>%v</td>", data.Pending)
-		t += fmt.Sprintf("<td>%v</td>", data.Running)
-		t += fmt.Sprintf("<td>%v</td>", data.CoolOff)
-		t += fmt.Sprintf("<td>%v</td>", data.FailureRate)
-		t += "</tr>\n"
+// helper function to create link to workflows page for given attribute
+func workflowsLink(key string) func(string) string {
+	return func(value string) string {
+		vals := url.Values{}
+		vals.Set(key, value)
+		return fmt.Sprintf("%s/workflows?%s", Config.Base, vals.Encode())
 	}
-	t += "</table>"
-	return t
 }
 
-// CliTable implements WMStatsMap interface
-func (wmap SiteStatsMap) CliTable() ([]string, [][]string, []int) {
-	headers := []string{
-		"Site", "Requests", "Pending", "Running", "CoolOff", "Failure Rate",
-	}
-	paddings := make([]int, len(headers))
-	for k, v := range headers {
-		paddings[k] = len(v)
-	}
-	var allValues [][]string
-	for site, data := range wmap {
-		if len(site) > paddings[0] {
-			paddings[0] = len(site)
-		}
-		requests := fmt.Sprintf("%v", data.Requests)
-		if len(requests) > paddings[1] {
-			paddings[1] = len(requests)
-		}
-		pending := fmt.Sprintf("%v", data.Pending)
-		if len(pending) > paddings[2] {
-			paddings[2] = len(pending)
-		}
-		running := fmt.Sprintf("%v", data.Running)
-		if len(running) > paddings[3] {
-			paddings[3] = len(running)
-		}
-		cooloff := fmt.Sprintf("%v", data.CoolOff)
-		if len(cooloff) > paddings[4] {
-			paddings[4] = len(cooloff)
-		}
-		failureRate := fmt.Sprintf("%v", data.FailureRate)
-		if len(failureRate) > paddings[5] {
-			paddings[5] = len(failureRate)
-		}
-		values := []string{
-			site, requests, pending, running, cooloff, failureRate,
+// helper function to create link to reqmgr2 page of given workflow
+func reqmgrLink(workflow string) string {
+	return fmt.Sprintf("https://cmsweb.cern.ch/reqmgr2/fetch?rid=%s", url.QueryEscape(workflow))
+}
+
+// FailureCountStats defines stats tables whose failure_rate column holds
+// number of failed jobs rather than percentage of failed jobs
+var FailureCountStats = []string{"agent", "cmssw"}
+
+// helper function to check if column of given stats table holds percentage
+// values, e.g. progress or failure rate
+func isRateColumn(stats, name string) bool {
+	if name == "failure_rate" {
+		for _, s := range FailureCountStats {
+			if s == stats {
+				return false
+			}
 		}
-		allValues = append(allValues, values)
 	}
-	return headers, allValues, paddings
+	return isPercentColumn(name)
 }
 
-// CampaignStatsMap
-type CampaignStatsMap map[string]CampaignStats
+// SiteStatsMap
+type SiteStatsMap map[string]SiteStats
 
-// HTMLTable implements WMStatsMap interface
-func (wmap CampaignStatsMap) HTMLTable() string {
-	t := `<table class="is-striped is-bordered" id="campaign-stats"><tr>`
-	t += `<th onclick="sortTable('campaign-stats', 0)">Campaign</th>`
-	t += `<th onclick="sortTable('campaign-stats', 1)">Requests</th>`
-	t += `<th onclick="sortTable('campaign-stats', 2)">Job Progress</th>`
-	t += `<th onclick="sortTable('campaign-stats', 3)">Event Progress</th>`
-	t += `<th onclick="sortTable('campaign-stats', 4)">Lumi Progress</th>`
-	t += `<th onclick="sortTable('campaign-stats', 5)">Failure Rate</th>`
-	t += `<th onclick="sortTable('campaign-stats', 6)">Cool off</th>`
-	t += "</tr>\n"
+// Table implements WMStatsMap interface
+func (wmap SiteStatsMap) Table() *Table {
+	t := &Table{
+		Name: "site-stats",
+		Columns: []Column{
+			{Name: "site", Title: "Site", Link: workflowsLink("site")},
+			{Name: "requests", Title: "Requests"},
+			{Name: "pending", Title: "Pending"},
+			{Name: "running", Title: "Running"},
+			{Name: "cooloff", Title: "CoolOff"},
+			{Name: "failure_rate", Title: "Failure Rate"},
+		},
+	}
 	for key, data := range wmap {
-		t += "<tr>"
-		link := fmt.Sprintf("%s/workflows?campaign=%s", Config.Base, key)
-		ahref := fmt.Sprintf("<a href=\"%s\">%s</a>", link, key)
-		t += fmt.Sprintf("<td>%v</td>", ahref)
-		t += fmt.Sprintf("<td>%v</td>", data.Requests)
-		t += fmt.Sprintf("<td>%v</td>", data.JobProgress)
-		t += fmt.Sprintf("<td>%v</td>", data.EventProgress)
-		t += fmt.Sprintf("<td>%v</td>", data.LumiProgress)
-		t += fmt.Sprintf("<td>%v</td>", data.FailureRate)
-		t += fmt.Sprintf("<td>%v</td>", data.CoolOff)
-		t += "</tr>\n"
+		row := TableRow{key, data.Requests, data.Pending, data.Running, data.CoolOff, data.FailureRate}
+		t.Rows = append(t.Rows, row)
 	}
-	t += "</table>"
 	return t
 }
 
-// CliTable implements WMStatsMap interface
-func (wmap CampaignStatsMap) CliTable() ([]string, [][]string, []int) {
-	headers := []string{
-		"Campaign", "Requests", "JobProgress", "EventProgress", "LumiProgress", "Failure Rate", "CoolOff",
-	}
-	paddings := make([]int, len(headers))
-	for k, v := range headers {
-		paddings[k] = len(v)
+// CampaignStatsMap
+type CampaignStatsMap map[string]CampaignStats
+
+// Table implements WMStatsMap interface
+func (wmap CampaignStatsMap) Table() *Table {
+	t := &Table{
+		Name: "campaign-stats",
+		Columns: []Column{
+			{Name: "campaign", Title: "Campaign", Link: workflowsLink("campaign")},
+			{Name: "requests", Title: "Requests"},
+			{Name: "pending", Title: "Pending"},
+			{Name: "running", Title: "Running"},
+			{Name: "job_progress", Title: "Job Progress"},
+			{Name: "event_progress", Title: "Event Progress"},
+			{Name: "lumi_progress", Title: "Lumi Progress"},
+			{Name: "failure_rate", Title: "Failure Rate"},
+			{Name: "cooloff", Title: "Cool off"},
+		},
 	}
-	var allValues [][]string
-	for campaign, data := range wmap {
-		if len(campaign) > paddings[0] {
-			paddings[0] = len(campaign)
-		}
-		requests := fmt.Sprintf("%v", data.Requests)
-		if len(requests) > paddings[1] {
-			paddings[1] = len(requests)
-		}
-		jobProgress := fmt.Sprintf("%v", data.JobProgress)
-		if len(jobProgress) > paddings[2] {
-			paddings[2] = len(jobProgress)
-		}
-		eventProgress := fmt.Sprintf("%v", data.EventProgress)
-		if len(eventProgress) > paddings[3] {
-			paddings[3] = len(eventProgress)
-		}
-		lumiProgress := fmt.Sprintf("%v", data.LumiProgress)
-		if len(lumiProgress) > paddings[4] {
-			paddings[4] = len(lumiProgress)
-		}
-		failureRate := fmt.Sprintf("%v", data.FailureRate)
-		if len(failureRate) > paddings[5] {
-			paddings[5] = len(failureRate)
-		}
-		cooloff := fmt.Sprintf("%v", data.CoolOff)
-		if len(cooloff) > paddings[6] {
-			paddings[6] = len(cooloff)
-		}
-		values := []string{
-			campaign, requests, jobProgress, eventProgress, lumiProgress, failureRate, cooloff,
-		}
-		allValues = append(allValues, values)
+	for key, data := range wmap {
+		row := TableRow{key, data.Requests, data.Pending, data.Running, data.JobProgress, data.EventProgress, data.LumiProgress, data.FailureRate, data.CoolOff}
+		t.Rows = append(t.Rows, row)
 	}
-	return headers, allValues, paddings
+	return t
 }
 
 // AgentStatsMap
 type AgentStatsMap map[string]AgentStats
 
-// HTMLTable implements WMStatsMap interface
-func (wmap AgentStatsMap) HTMLTable() string {
-	t := `<table class="is-striped is-bordered" id="agent-stats"><tr>`
-	t += `<th onclick="sortTable('agent-stats', 0)">Agent</th>`
-	t += `<th onclick="sortTable('agent-stats', 1)">Requests</th>`
-	t += `<th onclick="sortTable('agent-stats', 2)">Job Progress</th>`
-	t += `<th onclick="sortTable('agent-stats', 3)">Failure Rate</th>`
-	t += `<th onclick="sortTable('agent-stats', 4)">Cool off</th>`
-	t += "</tr>\n"
+// Table implements WMStatsMap interface
+func (wmap AgentStatsMap) Table() *Table {
+	t := &Table{
+		Name: "agent-stats",
+		Columns: []Column{
+			{Name: "agent", Title: "Agent", Link: workflowsLink("agent")},
+			{Name: "requests", Title: "Requests"},
+			{Name: "pending", Title: "Pending"},
+			{Name: "running", Title: "Running"},
+			{Name: "job_progress", Title: "Job Progress"},
+			{Name: "failure_rate", Title: "Failure Rate"},
+			{Name: "cooloff", Title: "Cool off"},
+		},
+	}
 	for key, data := range wmap {
-		t += "<tr>"
-		t += fmt.Sprintf("<td>%v</td>", key)
-		t += fmt.Sprintf("<td>%v</td>", data.Requests)
-		t += fmt.Sprintf("<td>%v</td>", data.JobProgress)
-		t += fmt.Sprintf("<td>%v</td>", data.FailureRate)
-		t += fmt.Sprintf("<td>%v</td>", data.CoolOff)
-		t += "</tr>\n"
+		row := TableRow{key, data.Requests, data.Pending, data.Running, data.JobProgress, data.FailureRate, data.CoolOff}
+		t.Rows = append(t.Rows, row)
 	}
-	t += "</table>"
 	return t
 }
 
-// CliTable implements WMStatsMap interface
-func (wmap AgentStatsMap) CliTable() ([]string, [][]string, []int) {
-	headers := []string{
-		"Agent", "Requests", "JobProgress", "Failure Rate", "CoolOff",
-	}
-	paddings := make([]int, len(headers))
-	for k, v := range headers {
-		paddings[k] = len(v)
-	}
-	var allValues [][]string
-	for agent, data := range wmap {
-		if len(agent) > paddings[0] {
-			paddings[0] = len(agent)
-		}
-		requests := fmt.Sprintf("%v", data.Requests)
-		if len(requests) > paddings[1] {
-			paddings[1] = len(requests)
-		}
-		jobProgress := fmt.Sprintf("%v", data.JobProgress)
-		if len(jobProgress) > paddings[2] {
-			paddings[2] = len(jobProgress)
-		}
-		failureRate := fmt.Sprintf("%v", data.FailureRate)
-		if len(failureRate) > paddings[3] {
-			paddings[3] = len(failureRate)
-		}
-		cooloff := fmt.Sprintf("%v", data.CoolOff)
-		if len(cooloff) > paddings[4] {
-			paddings[4] = len(cooloff)
-		}
-		values := []string{
-			agent, requests, jobProgress, failureRate, cooloff,
-		}
-		allValues = append(allValues, values)
-	}
-	return headers, allValues, paddings
-}
-
 // CMSSWStatsMap
 type CMSSWStatsMap map[string]CMSSWStats
 
-// HTMLTable implements WMStatsMap interface
-func (wmap CMSSWStatsMap) HTMLTable() string {
-	t := `<table class="is-striped is-bordered" id="cmssw-stats"><tr>`
-	t += `<th onclick="sortTable('cmssw-stats', 0)">CMSSW</th>`
-	t += `<th onclick="sortTable('cmssw-stats', 1)">Requests</th>`
-	t += `<th onclick="sortTable('cmssw-stats', 2)">Job Progress</th>`
-	t += `<th onclick="sortTable('cmssw-stats', 3)">Event Progress</th>`
-	t += `<th onclick="sortTable('cmssw-stats', 4)">Lumi Progress</th>`
-	t += `<th onclick="sortTable('cmssw-stats', 5)">Failure Rate</th>`
-	t += `<th onclick="sortTable('cmssw-stats', 6)">Cool off</th>`
-	t += "</tr>\n"
+// Table implements WMStatsMap interface
+func (wmap CMSSWStatsMap) Table() *Table {
+	t := &Table{
+		Name: "cmssw-stats",
+		Columns: []Column{
+			{Name: "cmssw", Title: "CMSSW", Link: workflowsLink("cmssw")},
+			{Name: "requests", Title: "Requests"},
+			{Name: "pending", Title: "Pending"},
+			{Name: "running", Title: "Running"},
+			{Name: "job_progress", Title: "Job Progress"},
+			{Name: "event_progress", Title: "Event Progress"},
+			{Name: "lumi_progress", Title: "Lumi Progress"},
+			{Name: "failure_rate", Title: "Failure Rate"},
+			{Name: "cooloff", Title: "Cool off"},
+		},
+	}
 	for key, data := range wmap {
-		t += "<tr>"
-		t += fmt.Sprintf("<td>%v</td>", key)
-		t += fmt.Sprintf("<td>%v</td>", data.Requests)
-		t += fmt.Sprintf("<td>%v</td>", data.JobProgress)
-		t += fmt.Sprintf("<td>%v</td>", data.EventProgress)
-		t += fmt.Sprintf("<td>%v</td>", data.LumiProgress)
-		t += fmt.Sprintf("<td>%v</td>", data.FailureRate)
-		t += fmt.Sprintf("<td>%v</td>", data.CoolOff)
-		t += "</tr>\n"
+		row := TableRow{key, data.Requests, data.Pending, data.Running, data.JobProgress, data.EventProgress, data.LumiProgress, data.FailureRate, data.CoolOff}
+		t.Rows = append(t.Rows, row)
 	}
-	t += "</table>"
 	return t
 }
 
-// CliTable implements WMStatsMap interface
-func (wmap CMSSWStatsMap) CliTable() ([]string, [][]string, []int) {
-	headers := []string{
-		"CMSSW", "Requests", "JobProgress", "EventProgress", "LumiProgress", "Failure Rate", "CoolOff",
-	}
-	paddings := make([]int, len(headers))
-	for k, v := range headers {
-		paddings[k] = len(v)
+// WorkflowMap provides list of workflows for a given key, e.g. campaign
+type WorkflowMap map[string][]Workflow
+
+// helper function to create table from list of workflows
+func workflowTable(workflows []Workflow) *Table {
+	t := &Table{
+		Name: "wmap",
+		Columns: []Column{
+			{Name: "workflow", Title: "Workflow", Link: reqmgrLink},
+			{Name: "status", Title: "Status"},
+			{Name: "type", Title: "Type"},
+			{Name: "priority", Title: "Priority"},
+			{Name: "queue_injection", Title: "Queue Injection"},
+			{Name: "job_progress", Title: "Job Progress"},
+			{Name: "event_progress", Title: "Event Progress"},
+			{Name: "lumi_progress", Title: "Lumi Progress"},
+			{Name: "failure_rate", Title: "Failure Rate"},
+			{Name: "estimated_completion", Title: "Estimated completion"},
+			{Name: "cooloff", Title: "Cool off"},
+		},
 	}
-	var allValues [][]string
-	for cmssw, data := range wmap {
-		if len(cmssw) > paddings[0] {
-			paddings[0] = len(cmssw)
+	for _, data := range workflows {
+		row := TableRow{
+			data.Workflow, data.Status, data.Type, data.Priority, data.QueueInjection,
+			data.JobProgress, data.EventProgress, data.LumiProgress, data.FailureRate,
+			data.EstimatedCompletion, data.CoolOff,
 		}
-		requests := fmt.Sprintf("%v", data.Requests)
-		if len(requests) > paddings[1] {
-			paddings[1] = len(requests)
+		t.Rows = append(t.Rows, row)
+	}
+	return t
+}
+
+// workflowSitesTable returns table with per-site job breakdown of given workflow
+func workflowSitesTable(winfo WorkflowInfo) *Table {
+	t := &Table{
+		Name: "workflow-sites",
+		Columns: []Column{
+			{Name: "site", Title: "Site"},
+			{Name: "pending", Title: "Pending"},
+			{Name: "running", Title: "Running"},
+			{Name: "cooloff", Title: "CoolOff"},
+			{Name: "success", Title: "Success"},
+			{Name: "failure", Title: "Failure"},
+			{Name: "failure_rate", Title: "Failure Rate"},
+		},
+	}
+	for site, status := range winfo.SiteJobs {
+		failure := status.Failure.Sum()
+		var rate float64
+		if tot := status.Success + failure; tot != 0 {
+			rate = 100 * float64(failure) / float64(tot)
 		}
-		jobProgress := fmt.Sprintf("%v", data.JobProgress)
-		if len(jobProgress) > paddings[2] {
-			paddings[2] = len(jobProgress)
+		row := TableRow{
+			site, status.Submitted.Pending, status.Submitted.Running,
+			status.CoolOff.Sum(), status.Success, failure, rate,
 		}
-		eventProgress := fmt.Sprintf("%v", data.EventProgress)
-		if len(eventProgress) > paddings[3] {
-			paddings[3] = len(eventProgress)
+		t.Rows = append(t.Rows, row)
+	}
+	return t
+}
+
+// helper function to add wmstats source to internal server link, the
+// external links are returned as is
+func sourceLink(link, source string) string {
+	if source == "" || !strings.HasPrefix(link, Config.Base+"/") {
+		return link
+	}
+	return fmt.Sprintf("%s&source=%s", link, url.QueryEscape(source))
+}
+
+// helper function to create HTML link with given table options, the query
+// represents original HTTP query whose non-table parameters are preserved
+func tableLink(path string, query url.Values, opts TableOptions) string {
+	vals := opts.Values()
+	for k, v := range query {
+		switch k {
+		case "sort", "order", "limit", "offset", "columns":
+		default:
+			vals[k] = v
 		}
-		lumiProgress := fmt.Sprintf("%v", data.LumiProgress)
-		if len(lumiProgress) > paddings[4] {
-			paddings[4] = len(lumiProgress)
+	}
+	return fmt.Sprintf("%s?%s", path, vals.Encode())
+}
+
+// HTMLHeader represents table header used by table template
+type HTMLHeader struct {
+	Title string // column title
+	Link  string // link to sort table by this column
+	Order string // current sort order if table is sorted by this column
+}
+
+// HTMLCell represents table cell used by table template
+type HTMLCell struct {
+	Value string // cell value
+	Link  string // optional cell link
+}
+
+// HTMLTableView represents table view used by table template
+type HTMLTableView struct {
+	Name     string       // table name
+	Headers  []HTMLHeader // table headers
+	Rows     [][]HTMLCell // table rows
+	Paginate bool         // show pagination controls
+	Prev     string       // link to previous page
+	Next     string       // link to next page
+	First    int          // index of first row on a page
+	Last     int          // index of last row on a page
+	Total    int          // total number of rows
+}
+
+// NewHTMLTableView creates table view of given table, the path and query
+// are used to construct sorting and pagination links
+func NewHTMLTableView(t *Table, path string, query url.Values) HTMLTableView {
+	opts := t.Options
+	view := HTMLTableView{Name: t.Name, Total: t.Total}
+	for _, c := range t.Columns {
+		o := opts
+		o.Sort = c.Name
+		o.Offset = 0
+		o.Order = "asc"
+		header := HTMLHeader{Title: c.Title}
+		if opts.Sort == c.Name {
+			header.Order = opts.Order
+			if opts.Order == "asc" {
+				o.Order = "desc"
+			}
 		}
-		failureRate := fmt.Sprintf("%v", data.FailureRate)
-		if len(failureRate) > paddings[5] {
-			paddings[5] = len(failureRate)
+		header.Link = tableLink(path, query, o)
+		view.Headers = append(view.Headers, header)
+	}
+	for _, row := range t.Rows {
+		var cells []HTMLCell
+		for i, v := range row {
+			cell := HTMLCell{Value: fmt.Sprintf("%v", v)}
+			if link := t.Columns[i].Link; link != nil {
+				cell.Link = sourceLink(link(cell.Value), query.Get("source"))
+			}
+			cells = append(cells, cell)
 		}
-		cooloff := fmt.Sprintf("%v", data.CoolOff)
-		if len(cooloff) > paddings[6] {
-			paddings[6] = len(cooloff)
+		view.Rows = append(view.Rows, cells)
+	}
+
+	// pagination
+	if opts.Limit > 0 && t.Total > opts.Limit {
+		view.Paginate = true
+		view.First = opts.Offset + 1
+		view.Last = opts.Offset + len(t.Rows)
+		if opts.Offset > 0 {
+			o := opts
+			o.Offset = opts.Offset - opts.Limit
+			if o.Offset < 0 {
+				o.Offset = 0
+			}
+			view.Prev = tableLink(path, query, o)
 		}
-		values := []string{
-			cmssw, requests, jobProgress, eventProgress, lumiProgress, failureRate, cooloff,
+		if view.Last < t.Total {
+			o := opts
+			o.Offset = view.Last
+			view.Next = tableLink(path, query, o)
 		}
-		allValues = append(allValues, values)
 	}
-	return headers, allValues, paddings
+	return view
 }
 
-// WorkflowMap provides list of workflows for a given key, e.g. campaign
-type WorkflowMap map[string][]Workflow
-
-// HTMLTable implements WMStatsMap interface
-func workflowHTMLTable(workflows []Workflow) string {
-	t := `<table class="is-striped is-bordered" id="wmap"><tr>`
-	t += `<th onclick="sortTable('wmap', 0)">Workflow</th>`
-	t += `<th onclick="sortTable('wmap', 1)">Status</th>`
-	t += `<th onclick="sortTable('wmap', 2)">Type</th>`
-	t += `<th onclick="sortTable('wmap', 3)">Priority</th>`
-	t += `<th onclick="sortTable('wmap', 4)">Queue Injection</th>`
-	t += `<th onclick="sortTable('wmap', 5)">Job Progress</th>`
-	t += `<th onclick="sortTable('wmap', 6)">Event Progress</th>`
-	t += `<th onclick="sortTable('wmap', 7)">Lumi Progress</th>`
-	t += `<th onclick="sortTable('wmap', 8)">Failure Rate</th>`
-	t += `<th onclick="sortTable('wmap', 9)">Estimated completion</th>`
-	t += `<th onclick="sortTable('wmap', 10)">Cool off</th>`
-	t += "</tr>\n"
-	for _, data := range workflows {
-		t += "<tr>"
-		link := fmt.Sprintf("https://cmsweb.cern.ch/reqmgr2/fetch?rid=%s", data.Workflow)
-		ahref := fmt.Sprintf("<a href=\"%s\">%s</a>", link, data.Workflow)
-		t += fmt.Sprintf("<td>%v</td>", ahref)
-		t += fmt.Sprintf("<td>%v</td>", data.Status)
-		t += fmt.Sprintf("<td>%v</td>", data.Type)
-		t += fmt.Sprintf("<td>%v</td>", data.Priority)
-		t += fmt.Sprintf("<td>%v</td>", data.QueueInjection)
-		t += fmt.Sprintf("<td>%v</td>", data.JobProgress)
-		t += fmt.Sprintf("<td>%v</td>", data.EventProgress)
-		t += fmt.Sprintf("<td>%v</td>", data.LumiProgress)
-		t += fmt.Sprintf("<td>%v</td>", data.FailureRate)
-		t += fmt.Sprintf("<td>%v</td>", data.EstimatedCompletion)
-		t += fmt.Sprintf("<td>%v</td>", data.CoolOff)
-		t += "</tr>\n"
-	}
-	t += "</table>"
-	return t
+// HTMLTable represents given table in HTML format using table template
+func HTMLTable(ctx context.Context, t *Table, path string, query url.Values) string {
+	tmpl := make(TmplRecord)
+	tmpl["Table"] = NewHTMLTableView(t, path, query)
+	return tmplPage(ctx, "table.tmpl", tmpl)
 }
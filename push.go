@@ -0,0 +1,219 @@
+package main
+
+// push module provides push of wmstats metrics to Prometheus Pushgateway
+// and Prometheus remote-write endpoint
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// PushGateway defines URL of Prometheus Pushgateway to push wmstats metrics to
+var PushGateway string
+
+// RemoteWrite defines URL of Prometheus remote-write endpoint to push wmstats metrics to
+var RemoteWrite string
+
+// PushJob defines job name of pushed wmstats metrics
+var PushJob = "wmstats"
+
+// PushTimeout defines timeout of push requests
+var PushTimeout = 10 * time.Second
+
+// helper function to check if push of wmstats metrics is configured
+func pushEnabled() bool {
+	return PushGateway != "" || RemoteWrite != ""
+}
+
+// helper function to get hostname of the server, it is used as instance
+// name of pushed metrics and host field of log records
+func hostName() string {
+	hostname := os.Getenv("HOSTNAME")
+	if hostname == "" {
+		var err error
+		hostname, err = os.Hostname()
+		if err != nil {
+			hostname = "localhost"
+		}
+	}
+	return hostname
+}
+
+// pushMetrics pushes aggregated metrics of given wmstats infos (keyed by
+// source label) to configured Pushgateway and remote-write endpoint
+func pushMetrics(infos map[string]*WMStatsInfo) error {
+	if !pushEnabled() || len(infos) == 0 {
+		return nil
+	}
+	prefix := Config.MetricsPrefix
+	if prefix == "" {
+		prefix = "wmstats"
+	}
+	registry := prometheus.NewRegistry()
+	reg := prometheus.WrapRegistererWithPrefix(prefix+"_", registry)
+	if err := reg.Register(infoCollector(infos)); err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: PushTimeout}
+	instance := hostName()
+	var errs []string
+	if PushGateway != "" {
+		err := push.New(PushGateway, PushJob).
+			Gatherer(registry).
+			Grouping("instance", instance).
+			Client(client).
+			Push()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("pushgateway: %v", err))
+		}
+	}
+	if RemoteWrite != "" {
+		if err := remoteWrite(client, registry, map[string]string{"job": PushJob, "instance": instance}); err != nil {
+			errs = append(errs, fmt.Sprintf("remote-write: %v", err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to push wmstats metrics, %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// helper function to send metrics of given gatherer to remote-write
+// endpoint, given labels are added to every time series
+func remoteWrite(client *http.Client, gatherer prometheus.Gatherer, labels map[string]string) error {
+	families, err := gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	data := writeRequest(families, labels, time.Now().UnixMilli())
+	req, err := http.NewRequest("POST", RemoteWrite, bytes.NewReader(snappy.Encode(nil, data)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", fmt.Sprintf("wmstats/%s", version))
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		err := fmt.Errorf("unexpected status %s from %s", resp.Status, RemoteWrite)
+		if body, _ := io.ReadAll(io.LimitReader(resp.Body, 512)); len(bytes.TrimSpace(body)) > 0 {
+			err = fmt.Errorf("%w: %s", err, bytes.TrimSpace(body))
+		}
+		return err
+	}
+	return nil
+}
+
+// helper function to encode gauge, counter and untyped metrics of given
+// metric families into remote-write WriteRequest protobuf message, see
+// https://github.com/prometheus/prometheus/blob/main/prompb/remote.proto
+func writeRequest(families []*dto.MetricFamily, labels map[string]string, timestamp int64) []byte {
+	var out []byte
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			var value float64
+			switch mf.GetType() {
+			case dto.MetricType_GAUGE:
+				value = m.GetGauge().GetValue()
+			case dto.MetricType_COUNTER:
+				value = m.GetCounter().GetValue()
+			case dto.MetricType_UNTYPED:
+				value = m.GetUntyped().GetValue()
+			default:
+				continue
+			}
+			// labels of time series should be sorted by their names
+			series := map[string]string{"__name__": mf.GetName()}
+			for k, v := range labels {
+				series[k] = v
+			}
+			for _, l := range m.GetLabel() {
+				series[l.GetName()] = l.GetValue()
+			}
+			var names []string
+			for k := range series {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+
+			var ts []byte
+			for _, name := range names {
+				var label []byte
+				label = protowire.AppendTag(label, 1, protowire.BytesType)
+				label = protowire.AppendString(label, name)
+				label = protowire.AppendTag(label, 2, protowire.BytesType)
+				label = protowire.AppendString(label, series[name])
+				ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+				ts = protowire.AppendBytes(ts, label)
+			}
+			var sample []byte
+			sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+			sample = protowire.AppendFixed64(sample, math.Float64bits(value))
+			sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+			sample = protowire.AppendVarint(sample, uint64(timestamp))
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, sample)
+
+			out = protowire.AppendTag(out, 1, protowire.BytesType)
+			out = protowire.AppendBytes(out, ts)
+		}
+	}
+	return out
+}
+
+// helper function to push metrics of wmstats sources of the server, the
+// metrics are pushed only if any source was refreshed after given time.
+// It returns time of the most recent refresh of the sources.
+func pushSourcesMetrics(ctx context.Context, sources *WMStatsSources, pushed time.Time) time.Time {
+	if !pushEnabled() || sources == nil {
+		return pushed
+	}
+	updated := pushed
+	for _, s := range sources.Status() {
+		if s.Updated.After(updated) {
+			updated = s.Updated
+		}
+	}
+	if !updated.After(pushed) {
+		return pushed
+	}
+	infos := make(map[string]*WMStatsInfo)
+	for _, label := range sources.Labels {
+		if info, err := wmstatsInfo(ctx, label, nil); err == nil {
+			infos[label] = info
+		}
+	}
+	_, span := startSpan(ctx, "push", attribute.Int("wmstats.sources", len(infos)))
+	time0 := time.Now()
+	err := pushMetrics(infos)
+	if err != nil {
+		logError(ctx, "metrics push failed", "error", err)
+	} else {
+		logDebug(ctx, "pushed wmstats metrics", "sources", len(infos), "duration", time.Since(time0))
+	}
+	endSpan(span, err)
+	return updated
+}
@@ -0,0 +1,243 @@
+package main
+
+// push_test module provides unit tests of push of wmstats metrics
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/golang/snappy"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// testSample represents decoded sample of remote-write time series
+type testSample struct {
+	Labels    map[string]string
+	Value     float64
+	Timestamp int64
+}
+
+// helper function to consume fields of protobuf message, it calls given
+// function with number, type and value of every field
+func consumeFields(t *testing.T, data []byte, fn func(protowire.Number, protowire.Type, []byte)) {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			t.Fatalf("invalid tag: %v", protowire.ParseError(n))
+		}
+		data = data[n:]
+		m := protowire.ConsumeFieldValue(num, typ, data)
+		if m < 0 {
+			t.Fatalf("invalid field %d: %v", num, protowire.ParseError(m))
+		}
+		fn(num, typ, data[:m])
+		data = data[m:]
+	}
+}
+
+// helper function to decode remote-write WriteRequest message into samples
+func decodeWriteRequest(t *testing.T, data []byte) []testSample {
+	var samples []testSample
+	consumeFields(t, data, func(num protowire.Number, typ protowire.Type, ts []byte) {
+		if num != 1 || typ != protowire.BytesType {
+			t.Fatalf("unexpected field %d of WriteRequest", num)
+		}
+		ts, _ = protowire.ConsumeBytes(ts)
+		sample := testSample{Labels: make(map[string]string)}
+		var names []string
+		consumeFields(t, ts, func(num protowire.Number, typ protowire.Type, msg []byte) {
+			msg, _ = protowire.ConsumeBytes(msg)
+			switch num {
+			case 1: // label
+				var name, value string
+				consumeFields(t, msg, func(num protowire.Number, typ protowire.Type, v []byte) {
+					s, _ := protowire.ConsumeString(v)
+					if num == 1 {
+						name = s
+					} else {
+						value = s
+					}
+				})
+				names = append(names, name)
+				sample.Labels[name] = value
+			case 2: // sample
+				consumeFields(t, msg, func(num protowire.Number, typ protowire.Type, v []byte) {
+					if num == 1 {
+						bits, _ := protowire.ConsumeFixed64(v)
+						sample.Value = math.Float64frombits(bits)
+					} else {
+						ts, _ := protowire.ConsumeVarint(v)
+						sample.Timestamp = int64(ts)
+					}
+				})
+			}
+		})
+		for i := 1; i < len(names); i++ {
+			if names[i-1] >= names[i] {
+				t.Errorf("labels of time series are not sorted: %v", names)
+			}
+		}
+		samples = append(samples, sample)
+	})
+	return samples
+}
+
+// TestWriteRequest tests encoding of metrics into remote-write message
+func TestWriteRequest(t *testing.T) {
+	families := []*dto.MetricFamily{
+		{
+			Name: proto.String("wmstats_site_running"),
+			Type: dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{{
+				Label: []*dto.LabelPair{{Name: proto.String("site"), Value: proto.String("T1_US_FNAL")}},
+				Gauge: &dto.Gauge{Value: proto.Float64(12.5)},
+			}},
+		},
+		{
+			Name:   proto.String("wmstats_refresh_failures_total"),
+			Type:   dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{{Counter: &dto.Counter{Value: proto.Float64(3)}}},
+		},
+		{
+			Name:   proto.String("wmstats_untyped"),
+			Type:   dto.MetricType_UNTYPED.Enum(),
+			Metric: []*dto.Metric{{Untyped: &dto.Untyped{Value: proto.Float64(-1)}}},
+		},
+		// histograms are not supported and skipped
+		{
+			Name:   proto.String("wmstats_histogram"),
+			Type:   dto.MetricType_HISTOGRAM.Enum(),
+			Metric: []*dto.Metric{{Histogram: &dto.Histogram{SampleCount: proto.Uint64(1)}}},
+		},
+	}
+	job := map[string]string{"job": "wmstats"}
+	expected := []testSample{
+		{map[string]string{"__name__": "wmstats_site_running", "job": "wmstats", "site": "T1_US_FNAL"}, 12.5, 1000},
+		{map[string]string{"__name__": "wmstats_refresh_failures_total", "job": "wmstats"}, 3, 1000},
+		{map[string]string{"__name__": "wmstats_untyped", "job": "wmstats"}, -1, 1000},
+	}
+	samples := decodeWriteRequest(t, writeRequest(families, job, 1000))
+	if !reflect.DeepEqual(samples, expected) {
+		t.Errorf("got\n%v\nexpected\n%v", samples, expected)
+	}
+}
+
+// TestPushMetrics tests push of wmstats metrics to Pushgateway and
+// remote-write endpoint
+func TestPushMetrics(t *testing.T) {
+	defer func(gw, rw, prefix string) {
+		PushGateway, RemoteWrite, Config.MetricsPrefix = gw, rw, prefix
+	}(PushGateway, RemoteWrite, Config.MetricsPrefix)
+
+	var mutex sync.Mutex
+	var samples []testSample
+	var gwPath string
+	gwFamilies := make(map[string]*dto.MetricFamily)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.URL.Path == "/api/v1/write" {
+			body, _ := io.ReadAll(r.Body)
+			data, err := snappy.Decode(nil, body)
+			if err != nil || r.Header.Get("Content-Encoding") != "snappy" {
+				http.Error(w, "invalid snappy payload", http.StatusBadRequest)
+				return
+			}
+			samples = decodeWriteRequest(t, data)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		gwPath = r.Method + " " + r.URL.Path
+		dec := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+		for {
+			mf := &dto.MetricFamily{}
+			if err := dec.Decode(mf); err != nil {
+				break
+			}
+			gwFamilies[mf.GetName()] = mf
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	PushGateway, RemoteWrite, Config.MetricsPrefix = srv.URL, srv.URL+"/api/v1/write", "wmstats"
+
+	info := &WMStatsInfo{
+		SiteStatsMap:  SiteStatsMap{"T1_US_FNAL": {Requests: 2, Running: 10, FailJobs: 4}},
+		AgentStatsMap: AgentStatsMap{"vocms0250": {Requests: 3, FailureRate: 40}},
+	}
+	if err := pushMetrics(map[string]*WMStatsInfo{"prod": info}); err != nil {
+		t.Fatal(err)
+	}
+	instance := hostName()
+	if gwPath != "PUT /metrics/job/wmstats/instance/"+instance {
+		t.Errorf("wrong Pushgateway request %s", gwPath)
+	}
+	tests := []struct {
+		name   string
+		labels map[string]string
+		value  float64
+	}{
+		{"wmstats_site_running", map[string]string{"source": "prod", "site": "T1_US_FNAL"}, 10},
+		{"wmstats_site_failed_jobs", map[string]string{"source": "prod", "site": "T1_US_FNAL"}, 4},
+		{"wmstats_agent_requests", map[string]string{"source": "prod", "agent": "vocms0250"}, 3},
+		{"wmstats_agent_failed_jobs", map[string]string{"source": "prod", "agent": "vocms0250"}, 40},
+	}
+	for _, tt := range tests {
+		// remote-write time series carry job and instance labels
+		var found bool
+		for _, s := range samples {
+			if s.Labels["__name__"] != tt.name {
+				continue
+			}
+			found = true
+			labels := map[string]string{"__name__": tt.name, "job": PushJob, "instance": instance}
+			for k, v := range tt.labels {
+				labels[k] = v
+			}
+			if !reflect.DeepEqual(s.Labels, labels) || s.Value != tt.value || s.Timestamp == 0 {
+				t.Errorf("%s: wrong remote-write sample %v", tt.name, s)
+			}
+		}
+		if !found {
+			t.Errorf("%s: no remote-write sample", tt.name)
+		}
+
+		// Pushgateway adds job and instance labels from grouping key
+		mf, ok := gwFamilies[tt.name]
+		if !ok || len(mf.GetMetric()) != 1 {
+			t.Errorf("%s: no Pushgateway metric", tt.name)
+			continue
+		}
+		m := mf.GetMetric()[0]
+		labels := make(map[string]string)
+		for _, l := range m.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		if !reflect.DeepEqual(labels, tt.labels) || m.GetGauge().GetValue() != tt.value {
+			t.Errorf("%s: wrong Pushgateway metric %v", tt.name, m)
+		}
+	}
+
+	// errors of push endpoints are reported along with response body
+	RemoteWrite = srv.URL + "/api/v1/write"
+	PushGateway = ""
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	})
+	err := pushMetrics(map[string]*WMStatsInfo{"prod": info})
+	if err == nil || !strings.Contains(err.Error(), "400 Bad Request") || !strings.Contains(err.Error(), "out of order sample") {
+		t.Errorf("wrong error of failed push %v", err)
+	}
+}
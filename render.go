@@ -0,0 +1,221 @@
+package main
+
+// wmstats render module provides text table renderer for terminal output
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// NoColor disables ANSI colors in CLI output
+var NoColor bool
+
+// FailureRateWarning and FailureRateCritical define failure rate thresholds
+// (in percents) used to colorize failure rate values
+var (
+	FailureRateWarning  = 5.0
+	FailureRateCritical = 10.0
+)
+
+// ANSI colors used by text renderer
+const (
+	ansiRed    = "\033[31m"
+	ansiYellow = "\033[33m"
+)
+
+// TextRenderer renders tables as aligned text
+type TextRenderer struct {
+	Color bool // use ANSI colors
+	Width int  // max line width, 0 means no limit
+	// Style returns optional ANSI style of given cell, row -1 represents header
+	Style func(row, col int) string
+}
+
+// NewTextRenderer creates text renderer for given writer, colors and
+// terminal width truncation are only used if writer is a terminal
+func NewTextRenderer(w io.Writer) *TextRenderer {
+	r := &TextRenderer{}
+	if f, ok := w.(*os.File); ok {
+		if width, _, err := terminalSize(int(f.Fd())); err == nil && width > 0 {
+			r.Width = width
+			r.Color = !NoColor && os.Getenv("NO_COLOR") == ""
+		}
+	}
+	return r
+}
+
+// helper function to get display width of a rune, wide east asian runes
+// occupy two terminal cells while combining marks do not occupy any
+func runeWidth(r rune) int {
+	switch {
+	case r == 0 || unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Me, r) || unicode.Is(unicode.Cf, r):
+		return 0
+	case r >= 0x1100 && r <= 0x115F, // Hangul Jamo
+		r >= 0x2E80 && r <= 0x303E, // CJK radicals and punctuation
+		r >= 0x3041 && r <= 0x33FF, // Hiragana, Katakana, CJK compatibility
+		r >= 0x3400 && r <= 0x4DBF, // CJK extension A
+		r >= 0x4E00 && r <= 0x9FFF, // CJK unified ideographs
+		r >= 0xA000 && r <= 0xA4CF, // Yi
+		r >= 0xAC00 && r <= 0xD7A3, // Hangul syllables
+		r >= 0xF900 && r <= 0xFAFF, // CJK compatibility ideographs
+		r >= 0xFE30 && r <= 0xFE4F, // CJK compatibility forms
+		r >= 0xFF00 && r <= 0xFF60, // fullwidth forms
+		r >= 0xFFE0 && r <= 0xFFE6,
+		r >= 0x1F300 && r <= 0x1F64F, // emoji
+		r >= 0x1F900 && r <= 0x1F9FF,
+		r >= 0x20000 && r <= 0x3FFFD: // CJK extensions
+		return 2
+	}
+	return 1
+}
+
+// textWidth returns display width of given string
+func textWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		width += runeWidth(r)
+	}
+	return width
+}
+
+// truncateText truncates given string to given display width
+func truncateText(s string, width int) string {
+	if textWidth(s) <= width {
+		return s
+	}
+	if width <= 0 {
+		return ""
+	}
+	var out strings.Builder
+	used := 0
+	for _, r := range s {
+		w := runeWidth(r)
+		// reserve one cell for ellipsis
+		if used+w > width-1 {
+			break
+		}
+		out.WriteRune(r)
+		used += w
+	}
+	out.WriteString("…")
+	return out.String()
+}
+
+// helper function to pad given string to given display width
+func padText(s string, width int, right bool) string {
+	n := width - textWidth(s)
+	if n <= 0 {
+		return s
+	}
+	if right {
+		return strings.Repeat(" ", n) + s
+	}
+	return s + strings.Repeat(" ", n)
+}
+
+// helper function to check if column represents percentage values
+func isPercentColumn(name string) bool {
+	return strings.HasSuffix(name, "_progress") || strings.HasSuffix(name, "_rate") || name == "queue_injection"
+}
+
+// helper function to format table cell, percentages use fixed precision
+func cellText(c Column, v interface{}) string {
+	if val, ok := v.(float64); ok && isPercentColumn(c.Name) {
+		return strconv.FormatFloat(val, 'f', 2, 64)
+	}
+	return formatValue(v)
+}
+
+// helper function to get color of table cell
+func cellColor(c Column, v interface{}) string {
+	if c.Name != "failure_rate" {
+		return ""
+	}
+	val, ok := v.(float64)
+	switch {
+	case !ok:
+		return ""
+	case val >= FailureRateCritical:
+		return ansiRed
+	case val >= FailureRateWarning:
+		return ansiYellow
+	}
+	return ""
+}
+
+// Lines returns header and rows of given table as aligned text lines.
+// Numeric columns are right aligned and lines are truncated to the
+// renderer width.
+func (r *TextRenderer) Lines(t *Table) []string {
+	cells := make([][]string, len(t.Rows)+1)
+	widths := make([]int, len(t.Columns))
+	numeric := make([]bool, len(t.Columns))
+	for k, c := range t.Columns {
+		cells[0] = append(cells[0], c.Title)
+		widths[k] = textWidth(c.Title)
+		numeric[k] = len(t.Rows) > 0
+	}
+	for i, row := range t.Rows {
+		for k, v := range row {
+			val := cellText(t.Columns[k], v)
+			if w := textWidth(val); w > widths[k] {
+				widths[k] = w
+			}
+			if !isNumber(v) {
+				numeric[k] = false
+			}
+			cells[i+1] = append(cells[i+1], val)
+		}
+	}
+	var lines []string
+	for i, vals := range cells {
+		var cols []string
+		used := 0
+		for k, val := range vals {
+			width := widths[k]
+			if r.Width > 0 {
+				if used >= r.Width {
+					break
+				}
+				if width > r.Width-used {
+					width = r.Width - used
+					val = truncateText(val, width)
+				}
+				used += width + 1
+			}
+			val = padText(val, width, numeric[k])
+			if k == len(vals)-1 && !numeric[k] {
+				val = strings.TrimRight(val, " ")
+			}
+			var style string
+			if r.Color && i > 0 {
+				style = cellColor(t.Columns[k], t.Rows[i-1][k])
+			}
+			if r.Style != nil {
+				style += r.Style(i-1, k)
+			}
+			if style != "" {
+				val = style + val + ansiReset
+			}
+			cols = append(cols, val)
+		}
+		lines = append(lines, strings.Join(cols, " "))
+	}
+	return lines
+}
+
+// Render writes given table as aligned text to provided writer
+func (r *TextRenderer) Render(w io.Writer, t *Table) error {
+	for _, line := range r.Lines(t) {
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
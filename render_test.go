@@ -0,0 +1,93 @@
+package main
+
+// render_test module provides unit tests of text table renderer
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestTextWidth tests display width of strings
+func TestTextWidth(t *testing.T) {
+	tests := []struct {
+		text  string
+		width int
+	}{
+		{"", 0},
+		{"T1_US_FNAL", 10},
+		{"café", 4},
+		{"café", 4}, // combining accent
+		{"日本語", 6},
+		{"한국", 4},
+		{"ｗｍ", 4}, // fullwidth forms
+		{"a🙂b", 4},
+		{"a​b", 2}, // zero width space
+		{"…", 1},
+	}
+	for _, tt := range tests {
+		if w := textWidth(tt.text); w != tt.width {
+			t.Errorf("textWidth(%q) = %d, expected %d", tt.text, w, tt.width)
+		}
+	}
+}
+
+// TestTruncateText tests truncation of strings to display width
+func TestTruncateText(t *testing.T) {
+	tests := []struct {
+		text     string
+		width    int
+		expected string
+	}{
+		{"T1_US_FNAL", 20, "T1_US_FNAL"},
+		{"T1_US_FNAL", 10, "T1_US_FNAL"},
+		{"T1_US_FNAL", 9, "T1_US_FN…"},
+		{"T1_US_FNAL", 1, "…"},
+		{"T1_US_FNAL", 0, ""},
+		{"T1_US_FNAL", -1, ""},
+		{"", 0, ""},
+		{"日本語", 6, "日本語"},
+		{"日本語", 5, "日本…"},
+		// wide rune which does not fit is dropped as a whole
+		{"日本語", 4, "日…"},
+		{"a日本", 4, "a日…"},
+		{"café latte", 5, "café…"},
+	}
+	for _, tt := range tests {
+		out := truncateText(tt.text, tt.width)
+		if out != tt.expected {
+			t.Errorf("truncateText(%q, %d) = %q, expected %q", tt.text, tt.width, out, tt.expected)
+		}
+		if tt.width >= 0 && textWidth(out) > tt.width {
+			t.Errorf("truncateText(%q, %d) = %q is wider than %d", tt.text, tt.width, out, tt.width)
+		}
+	}
+}
+
+// TestTextRendererLines tests alignment of text table lines
+func TestTextRendererLines(t *testing.T) {
+	tab := &Table{
+		Columns: []Column{{Name: "site", Title: "Site"}, {Name: "requests", Title: "Requests"}},
+		Rows:    []TableRow{{"日本", 1}, {"T1_US_FNAL", 100}},
+	}
+	r := &TextRenderer{}
+	expected := []string{
+		"Site       Requests",
+		"日本              1",
+		"T1_US_FNAL      100",
+	}
+	lines := r.Lines(tab)
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("got\n%s\nexpected\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+
+	// lines are truncated to renderer width
+	r.Width = 8
+	for _, line := range r.Lines(tab) {
+		if w := textWidth(line); w > r.Width {
+			t.Errorf("line %q is wider than %d", line, r.Width)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+// retry module provides retries with exponential backoff and circuit
+// breaker for upstream requests
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// FetchRetries defines number of retries of failed upstream requests
+var FetchRetries = 2
+
+// FetchBackoff defines initial backoff interval between retries
+var FetchBackoff = time.Second
+
+// FetchMaxBackoff defines max backoff interval between retries
+var FetchMaxBackoff = 30 * time.Second
+
+// CircuitThreshold defines number of consecutive failures which opens circuit breaker
+var CircuitThreshold = 5
+
+// CircuitCooldown defines interval after which open circuit breaker allows a trial request
+var CircuitCooldown = time.Minute
+
+// ErrCircuitOpen is returned when circuit breaker of upstream is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// fetch metrics
+var (
+	FetchAttempts         uint64 // total number of upstream requests
+	FetchFailures         uint64 // total number of failed upstream requests
+	FetchRetriesTotal     uint64 // total number of retries of upstream requests
+	FetchCircuitRejected  uint64 // total number of requests rejected by open circuit breaker
+	fetchLatencyMicrosSum uint64 // sum of upstream request latencies in microseconds
+)
+
+// CircuitBreaker stops requests to failing upstream. It opens after given
+// number of consecutive failures and allows single trial request once
+// cooldown interval is passed, the successful trial closes the breaker.
+type CircuitBreaker struct {
+	Threshold int           // number of consecutive failures which opens the breaker
+	Cooldown  time.Duration // interval after which open breaker allows a trial request
+	failures  int           // number of consecutive failures
+	openedAt  time.Time     // time when breaker was opened
+	trial     bool          // trial request is in progress
+	mutex     sync.Mutex
+}
+
+// Allow checks if request is allowed by circuit breaker
+func (c *CircuitBreaker) Allow() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.Threshold <= 0 || c.failures < c.Threshold {
+		return true
+	}
+	if !c.trial && time.Since(c.openedAt) >= c.Cooldown {
+		c.trial = true
+		return true
+	}
+	return false
+}
+
+// Success records successful request
+func (c *CircuitBreaker) Success() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.failures = 0
+	c.trial = false
+}
+
+// Failure records failed request
+func (c *CircuitBreaker) Failure() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.failures++
+	c.trial = false
+	if c.Threshold > 0 && c.failures >= c.Threshold {
+		c.openedAt = time.Now()
+	}
+}
+
+// State returns state of circuit breaker: closed, open or half-open
+func (c *CircuitBreaker) State() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.Threshold <= 0 || c.failures < c.Threshold {
+		return "closed"
+	}
+	if c.trial || time.Since(c.openedAt) >= c.Cooldown {
+		return "half-open"
+	}
+	return "open"
+}
+
+// circuit breakers of upstream hosts
+var (
+	breakers     = make(map[string]*CircuitBreaker)
+	breakersLock sync.Mutex
+)
+
+// helper function to get circuit breaker of given URL host
+func circuitBreaker(rurl string) (string, *CircuitBreaker) {
+	host := rurl
+	if u, err := url.Parse(rurl); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+	b, ok := breakers[host]
+	if !ok {
+		b = &CircuitBreaker{Threshold: CircuitThreshold, Cooldown: CircuitCooldown}
+		breakers[host] = b
+	}
+	return host, b
+}
+
+// random source used for backoff jitter
+var (
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	jitterLock sync.Mutex
+)
+
+// helper function to get backoff interval of given retry attempt, it uses
+// exponential backoff with "equal jitter", i.e. random value in [d/2, d]
+func backoff(attempt int) time.Duration {
+	d := FetchBackoff
+	for i := 1; i < attempt && d < FetchMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > FetchMaxBackoff {
+		d = FetchMaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	jitterLock.Lock()
+	defer jitterLock.Unlock()
+	return d/2 + time.Duration(jitterRand.Int63n(int64(d/2)+1))
+}
+
+// helper function to check if failed request can be retried, i.e. network
+// errors and server side errors
+func retryable(err error) bool {
+	if errors.Is(err, ErrNotModified) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var cerr *CredentialsError
+	if errors.As(err, &cerr) {
+		return false
+	}
+	var ferr *FetchError
+	if errors.As(err, &ferr) {
+		return ferr.StatusCode >= 500 || ferr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// helper function to record metrics of upstream request
+func recordFetch(time0 time.Time, err error) {
+	atomic.AddUint64(&FetchAttempts, 1)
+	atomic.AddUint64(&fetchLatencyMicrosSum, uint64(time.Since(time0).Microseconds()))
+	if err != nil && !errors.Is(err, ErrNotModified) {
+		atomic.AddUint64(&FetchFailures, 1)
+	}
+}
+
+// helper function to fetch data of given URL within client span of upstream request
+func tracedFetch(ctx context.Context, rurl string, validators FetchValidators) ([]byte, FetchValidators, error) {
+	ctx, span := tracer.Start(ctx, "GET", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPMethodKey.String("GET"), semconv.HTTPURLKey.String(rurl)))
+	data, vals, err := fetchConditional(ctx, rurl, validators)
+	var ferr *FetchError
+	if errors.As(err, &ferr) {
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(ferr.StatusCode))
+	}
+	if errors.Is(err, ErrNotModified) {
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(http.StatusNotModified))
+		span.End()
+	} else {
+		endSpan(span, err)
+	}
+	return data, vals, err
+}
+
+// fetchWithRetries fetches data of given URL with retries, it respects
+// circuit breaker of URL host and stops retries when context is canceled
+func fetchWithRetries(ctx context.Context, rurl string, validators FetchValidators) ([]byte, FetchValidators, error) {
+	host, breaker := circuitBreaker(rurl)
+	ctx, span := startSpan(ctx, "fetch", attribute.String("wmstats.host", host))
+	if !breaker.Allow() {
+		atomic.AddUint64(&FetchCircuitRejected, 1)
+		err := fmt.Errorf("fetch %s: %w for %s", rurl, ErrCircuitOpen, host)
+		endSpan(span, err)
+		return nil, validators, err
+	}
+	var data []byte
+	var vals FetchValidators
+	var err error
+	for attempt := 0; attempt <= FetchRetries; attempt++ {
+		span.SetAttributes(attribute.Int("wmstats.fetch.attempts", attempt+1))
+		if attempt > 0 {
+			atomic.AddUint64(&FetchRetriesTotal, 1)
+			delay := backoff(attempt)
+			logWarn(ctx, "retry upstream request", "url", rurl, "attempt", attempt, "backoff", delay, "error", err)
+			select {
+			case <-ctx.Done():
+				endSpan(span, ctx.Err())
+				return nil, validators, ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+		time0 := time.Now()
+		data, vals, err = tracedFetch(ctx, rurl, validators)
+		recordFetch(time0, err)
+		if err == nil || errors.Is(err, ErrNotModified) {
+			breaker.Success()
+			span.End()
+			return data, vals, err
+		}
+		if !retryable(err) || ctx.Err() != nil {
+			break
+		}
+	}
+	breaker.Failure()
+	if breaker.State() == "open" {
+		logWarn(ctx, "circuit breaker is open", "host", host, "error", err)
+	}
+	endSpan(span, err)
+	return nil, validators, err
+}
+
+// fetchCollector exposes metrics of upstream requests
+type fetchCollector struct {
+	attempts *prometheus.Desc
+	failures *prometheus.Desc
+	retries  *prometheus.Desc
+	rejected *prometheus.Desc
+	latency  *prometheus.Desc
+	circuit  *prometheus.Desc
+}
+
+// helper function to create upstream requests metrics collector
+func newFetchCollector() *fetchCollector {
+	return &fetchCollector{
+		attempts: prometheus.NewDesc("fetch_attempts_total", "total number of upstream requests", nil, nil),
+		failures: prometheus.NewDesc("fetch_failures_total", "total number of failed upstream requests", nil, nil),
+		retries:  prometheus.NewDesc("fetch_retries_total", "total number of retries of upstream requests", nil, nil),
+		rejected: prometheus.NewDesc("fetch_circuit_rejected_total", "total number of requests rejected by open circuit breaker", nil, nil),
+		latency:  prometheus.NewDesc("fetch_latency_seconds", "latency of upstream requests", nil, nil),
+		circuit:  prometheus.NewDesc("fetch_circuit_open", "state of upstream circuit breaker, 1 if it is open or half-open", []string{"host"}, nil),
+	}
+}
+
+// Describe implements prometheus.Collector interface
+func (c *fetchCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{c.attempts, c.failures, c.retries, c.rejected, c.latency, c.circuit} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector interface
+func (c *fetchCollector) Collect(ch chan<- prometheus.Metric) {
+	attempts := atomic.LoadUint64(&FetchAttempts)
+	ch <- prometheus.MustNewConstMetric(c.attempts, prometheus.CounterValue, float64(attempts))
+	ch <- prometheus.MustNewConstMetric(c.failures, prometheus.CounterValue, float64(atomic.LoadUint64(&FetchFailures)))
+	ch <- prometheus.MustNewConstMetric(c.retries, prometheus.CounterValue, float64(atomic.LoadUint64(&FetchRetriesTotal)))
+	ch <- prometheus.MustNewConstMetric(c.rejected, prometheus.CounterValue, float64(atomic.LoadUint64(&FetchCircuitRejected)))
+	latency := float64(atomic.LoadUint64(&fetchLatencyMicrosSum)) / 1e6
+	ch <- prometheus.MustNewConstSummary(c.latency, attempts, latency, nil)
+
+	breakersLock.Lock()
+	hostBreakers := make(map[string]*CircuitBreaker)
+	for host, b := range breakers {
+		hostBreakers[host] = b
+	}
+	breakersLock.Unlock()
+	for host, b := range hostBreakers {
+		open := 0.0
+		if b.State() != "closed" {
+			open = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.circuit, prometheus.GaugeValue, open, host)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+// retry_test module provides unit tests of retries and circuit breaker
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestBackoff tests exponential backoff intervals with jitter
+func TestBackoff(t *testing.T) {
+	defer func(b, m time.Duration) { FetchBackoff, FetchMaxBackoff = b, m }(FetchBackoff, FetchMaxBackoff)
+	FetchBackoff, FetchMaxBackoff = time.Second, 10*time.Second
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second}, // capped by max backoff
+		{50, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			d := backoff(tt.attempt)
+			if d < tt.max/2 || d > tt.max {
+				t.Errorf("backoff(%d) = %v, expected value in [%v, %v]", tt.attempt, d, tt.max/2, tt.max)
+				break
+			}
+		}
+	}
+	FetchBackoff = 0
+	if d := backoff(3); d != 0 {
+		t.Errorf("backoff with zero interval = %v", d)
+	}
+}
+
+// TestCircuitBreaker tests state transitions of circuit breaker
+func TestCircuitBreaker(t *testing.T) {
+	cooldown := 20 * time.Millisecond
+	c := &CircuitBreaker{Threshold: 2, Cooldown: cooldown}
+	steps := []struct {
+		name   string
+		action func()
+		state  string
+		allow  bool
+	}{
+		{"initial", func() {}, "closed", true},
+		{"first failure", c.Failure, "closed", true},
+		{"threshold failures", c.Failure, "open", false},
+		{"cooldown passed", func() { time.Sleep(cooldown) }, "half-open", true},
+		// single trial request is allowed in half-open state
+		{"trial in progress", func() {}, "half-open", false},
+		{"failed trial", c.Failure, "open", false},
+		{"second cooldown", func() { time.Sleep(cooldown) }, "half-open", true},
+		{"successful trial", c.Success, "closed", true},
+	}
+	for _, s := range steps {
+		s.action()
+		if state := c.State(); state != s.state {
+			t.Errorf("%s: state %s, expected %s", s.name, state, s.state)
+		}
+		if allow := c.Allow(); allow != s.allow {
+			t.Errorf("%s: allow %v, expected %v", s.name, allow, s.allow)
+		}
+	}
+
+	// breaker with zero threshold is never open
+	c = &CircuitBreaker{}
+	for i := 0; i < 10; i++ {
+		c.Failure()
+	}
+	if !c.Allow() || c.State() != "closed" {
+		t.Errorf("breaker without threshold is %s", c.State())
+	}
+}
+
+// TestRetryable tests which errors of upstream requests are retried
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err   error
+		retry bool
+	}{
+		{errors.New("connection refused"), true},
+		{&FetchError{StatusCode: http.StatusBadGateway}, true},
+		{&FetchError{StatusCode: http.StatusTooManyRequests}, true},
+		{&FetchError{StatusCode: http.StatusNotFound}, false},
+		{&FetchError{StatusCode: http.StatusOK, Reason: "unexpected content type"}, false},
+		{ErrNotModified, false},
+		{context.Canceled, false},
+		{fmt.Errorf("fetch: %w", context.DeadlineExceeded), false},
+		{&CredentialsError{Source: "X509", Err: errors.New("no proxy")}, false},
+	}
+	for _, tt := range tests {
+		if retry := retryable(tt.err); retry != tt.retry {
+			t.Errorf("retryable(%v) = %v, expected %v", tt.err, retry, tt.retry)
+		}
+	}
+}
+
+// TestFetchWithRetries tests retries of failed requests and rejection of
+// requests by open circuit breaker
+func TestFetchWithRetries(t *testing.T) {
+	defer func(token string, retries int, b time.Duration, threshold int) {
+		Token, FetchRetries, FetchBackoff, CircuitThreshold = token, retries, b, threshold
+	}(Token, FetchRetries, FetchBackoff, CircuitThreshold)
+	Token, FetchRetries, FetchBackoff, CircuitThreshold = "test-token", 2, time.Millisecond, 2
+
+	var requests, failures int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result":[]}`))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		failures int32
+		requests int32
+		fail     bool
+	}{
+		{"no failures", 0, 1, false},
+		{"recovered after retries", 2, 3, false},
+		{"retries exhausted", 3, 3, true},
+		// breaker is opened by two failed fetches and rejects requests
+		{"retries exhausted again", 3, 3, true},
+		{"open circuit breaker", 0, 0, true},
+	}
+	for _, tt := range tests {
+		atomic.StoreInt32(&requests, 0)
+		atomic.StoreInt32(&failures, tt.failures)
+		data, _, err := fetchWithRetries(context.Background(), srv.URL, FetchValidators{})
+		if tt.fail != (err != nil) {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if !tt.fail && string(data) != `{"result":[]}` {
+			t.Errorf("%s: wrong data %q", tt.name, data)
+		}
+		if n := atomic.LoadInt32(&requests); n != tt.requests {
+			t.Errorf("%s: %d requests, expected %d", tt.name, n, tt.requests)
+		}
+	}
+	if _, _, err := fetchWithRetries(context.Background(), srv.URL, FetchValidators{}); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("wrong error of open circuit breaker %v", err)
+	}
+}
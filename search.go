@@ -0,0 +1,171 @@
+package main
+
+// wmstats search module
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"sort"
+	"strings"
+)
+
+// SearchTypes defines list of attributes we index, in order of presentation
+var SearchTypes = []string{"workflow", "campaign", "site", "cmssw", "agent", "prepid"}
+
+// SearchEntry represents single entry of search index
+type SearchEntry struct {
+	Type  string `json:"type"`  // attribute type, e.g. campaign
+	Value string `json:"value"` // attribute value
+	Count int    `json:"count"` // number of workflows associated with given value
+	Link  string `json:"link"`  // link to the page associated with given value
+	key   string // lower-case value used for matching
+}
+
+// SearchGroup represents group of search entries of the same type
+type SearchGroup struct {
+	Type    string        `json:"type"`    // attribute type
+	Total   int           `json:"total"`   // total number of matched entries
+	Entries []SearchEntry `json:"entries"` // matched entries (may be truncated)
+}
+
+// SearchIndex represents in-memory search index of wmstats attributes
+type SearchIndex struct {
+	Entries map[string][]SearchEntry // entries per type sorted by lower-case value
+}
+
+// NewSearchIndex builds search index from given workflow map
+func NewSearchIndex(wmap map[string]WorkflowInfo) *SearchIndex {
+	counts := make(map[string]map[string]int)
+	for _, stype := range SearchTypes {
+		counts[stype] = make(map[string]int)
+	}
+	add := func(stype string, values ...string) {
+		// count every value only once per workflow
+		seen := make(map[string]bool)
+		for _, v := range values {
+			if v == "" || seen[v] {
+				continue
+			}
+			seen[v] = true
+			counts[stype][v] += 1
+		}
+	}
+	for name, winfo := range wmap {
+		add("workflow", name)
+		add("campaign", winfo.Campaign)
+		add("site", winfo.Sites...)
+		add("cmssw", winfo.Releases...)
+		add("agent", winfo.Agents...)
+		add("prepid", winfo.PrepID)
+	}
+	idx := &SearchIndex{Entries: make(map[string][]SearchEntry)}
+	for stype, cmap := range counts {
+		var entries []SearchEntry
+		for v, c := range cmap {
+			entries = append(entries, SearchEntry{Type: stype, Value: v, Count: c, key: strings.ToLower(v)})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].key < entries[j].key
+		})
+		idx.Entries[stype] = entries
+	}
+	return idx
+}
+
+// helper function to check if all terms are present in a given key
+func matchTerms(key string, terms []string) bool {
+	for _, t := range terms {
+		if !strings.Contains(key, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// helper function to split query into lower-case terms
+func searchTerms(query string) []string {
+	return strings.Fields(strings.ToLower(query))
+}
+
+// match returns entries of given type matching query terms, entries which
+// start with the first term are listed first
+func (s *SearchIndex) match(stype string, terms []string) []SearchEntry {
+	var prefix, other []SearchEntry
+	if len(terms) == 0 {
+		return nil
+	}
+	for _, e := range s.Entries[stype] {
+		if !matchTerms(e.key, terms) {
+			continue
+		}
+		if strings.HasPrefix(e.key, terms[0]) {
+			prefix = append(prefix, e)
+		} else {
+			other = append(other, e)
+		}
+	}
+	return append(prefix, other...)
+}
+
+// Search performs search across all types and returns grouped results,
+// the limit controls maximum number of entries per group (0 means no limit)
+func (s *SearchIndex) Search(query string, limit int) []SearchGroup {
+	var groups []SearchGroup
+	if s == nil {
+		return groups
+	}
+	terms := searchTerms(query)
+	for _, stype := range SearchTypes {
+		entries := s.match(stype, terms)
+		if len(entries) == 0 {
+			continue
+		}
+		group := SearchGroup{Type: stype, Total: len(entries), Entries: entries}
+		if limit > 0 && len(entries) > limit {
+			group.Entries = entries[:limit]
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// Suggest provides type-ahead suggestions for given prefix. It uses binary
+// search over sorted entries to find prefix matches and falls back to
+// sub-string matches if there are not enough of them.
+func (s *SearchIndex) Suggest(query string, limit int) []SearchEntry {
+	var out []SearchEntry
+	if s == nil {
+		return out
+	}
+	prefix := strings.ToLower(strings.TrimSpace(query))
+	if prefix == "" {
+		return out
+	}
+	found := make(map[string]bool)
+	for _, stype := range SearchTypes {
+		entries := s.Entries[stype]
+		idx := sort.Search(len(entries), func(i int) bool {
+			return entries[i].key >= prefix
+		})
+		for i := idx; i < len(entries) && strings.HasPrefix(entries[i].key, prefix); i++ {
+			if limit > 0 && len(out) >= limit {
+				return out
+			}
+			out = append(out, entries[i])
+			found[stype+entries[i].key] = true
+		}
+	}
+	for _, stype := range SearchTypes {
+		for _, e := range s.Entries[stype] {
+			if limit > 0 && len(out) >= limit {
+				return out
+			}
+			if found[stype+e.key] || !strings.Contains(e.key, prefix) {
+				continue
+			}
+			out = append(out, e)
+		}
+	}
+	return out
+}
@@ -0,0 +1,122 @@
+package main
+
+// search_test module provides unit tests of wmstats search index
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"reflect"
+	"testing"
+)
+
+// helper function to create search index of test workflows
+func testSearchIndex() *SearchIndex {
+	wmap := map[string]WorkflowInfo{
+		"pdmvserv_Run2022A_ZeroBias": {
+			Campaign: "Run2022A", PrepID: "ReReco-Run2022A-0001",
+			Sites: []string{"T1_US_FNAL", "T2_CH_CERN"}, Releases: []string{"CMSSW_12_4_0"},
+			Agents: []string{"vocms0250.cern.ch"},
+		},
+		"pdmvserv_Run2022B_JetHT": {
+			Campaign: "Run2022B", PrepID: "ReReco-Run2022B-0002",
+			Sites: []string{"T1_US_FNAL", "T1_US_FNAL"}, Releases: []string{"CMSSW_12_4_0"},
+			Agents: []string{"vocms0251.cern.ch"},
+		},
+		"amaltaro_SC_Fall22": {
+			Campaign: "Fall22", Sites: []string{"T2_US_MIT"}, Releases: []string{"CMSSW_12_6_0"},
+		},
+	}
+	return NewSearchIndex(wmap)
+}
+
+// helper function to get type:value pairs of search entries
+func searchValues(entries []SearchEntry) []string {
+	var out []string
+	for _, e := range entries {
+		out = append(out, e.Type+":"+e.Value)
+	}
+	return out
+}
+
+// TestSearchIndexSearch tests search across all types of search index
+func TestSearchIndexSearch(t *testing.T) {
+	idx := testSearchIndex()
+	tests := []struct {
+		query  string
+		limit  int
+		groups map[string][]string // expected values per group
+		totals map[string]int      // expected totals per group
+	}{
+		{"", 0, map[string][]string{}, nil},
+		{"nothing", 0, map[string][]string{}, nil},
+		{"run2022a", 0, map[string][]string{
+			"workflow": {"workflow:pdmvserv_Run2022A_ZeroBias"},
+			"campaign": {"campaign:Run2022A"},
+			"prepid":   {"prepid:ReReco-Run2022A-0001"},
+		}, nil},
+		// entries which start with the first term are listed first
+		{"t2", 0, map[string][]string{
+			"site": {"site:T2_CH_CERN", "site:T2_US_MIT"},
+		}, nil},
+		{"us fnal", 0, map[string][]string{
+			"site": {"site:T1_US_FNAL"},
+		}, nil},
+		{"cmssw_12", 1, map[string][]string{
+			"cmssw": {"cmssw:CMSSW_12_4_0"},
+		}, map[string]int{"cmssw": 2}},
+	}
+	for _, tt := range tests {
+		groups := idx.Search(tt.query, tt.limit)
+		got := make(map[string][]string)
+		for _, g := range groups {
+			got[g.Type] = searchValues(g.Entries)
+			if total, ok := tt.totals[g.Type]; ok && g.Total != total {
+				t.Errorf("query %q, group %s: total %d, expected %d", tt.query, g.Type, g.Total, total)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.groups) {
+			t.Errorf("query %q: got %v, expected %v", tt.query, got, tt.groups)
+		}
+	}
+
+	// sites are counted once per workflow
+	for _, g := range idx.Search("fnal", 0) {
+		if g.Type == "site" && g.Entries[0].Count != 2 {
+			t.Errorf("wrong count of T1_US_FNAL: %d", g.Entries[0].Count)
+		}
+	}
+
+	// search of nil index returns no results
+	var empty *SearchIndex
+	if groups := empty.Search("run", 0); len(groups) != 0 {
+		t.Errorf("search of nil index returns %v", groups)
+	}
+}
+
+// TestSearchIndexSuggest tests type-ahead suggestions of search index
+func TestSearchIndexSuggest(t *testing.T) {
+	idx := testSearchIndex()
+	tests := []struct {
+		query    string
+		limit    int
+		expected []string
+	}{
+		{"", 10, nil},
+		{"   ", 10, nil},
+		{"zzz", 10, nil},
+		// prefix matches come first, then sub-string matches
+		{"run2022", 10, []string{"campaign:Run2022A", "campaign:Run2022B",
+			"workflow:pdmvserv_Run2022A_ZeroBias", "workflow:pdmvserv_Run2022B_JetHT",
+			"prepid:ReReco-Run2022A-0001", "prepid:ReReco-Run2022B-0002"}},
+		{"RUN2022", 3, []string{"campaign:Run2022A", "campaign:Run2022B", "workflow:pdmvserv_Run2022A_ZeroBias"}},
+		{"t1_", 0, []string{"site:T1_US_FNAL"}},
+		{"vocms", 1, []string{"agent:vocms0250.cern.ch"}},
+	}
+	for _, tt := range tests {
+		got := searchValues(idx.Suggest(tt.query, tt.limit))
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("suggest %q limit %d: got %v, expected %v", tt.query, tt.limit, got, tt.expected)
+		}
+	}
+}
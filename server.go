@@ -11,12 +11,15 @@ import (
 	"crypto/x509"
 	"fmt"
 	"html/template"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -26,8 +29,25 @@ import (
 	logging "github.com/vkuznet/http-logging"
 )
 
-// global variables
-var _top, _bottom, _header, _footer template.HTML
+// PageParts represents common parts of server pages
+type PageParts struct {
+	Top    template.HTML
+	Bottom template.HTML
+	Header template.HTML
+	Footer template.HTML
+}
+
+// global common parts of server pages, they are re-rendered when templates
+// are reloaded and therefore they are guarded by the lock
+var _pageParts PageParts
+var _pagePartsLock sync.RWMutex
+
+// helper function to get common parts of server pages
+func pageParts() PageParts {
+	_pagePartsLock.RLock()
+	defer _pagePartsLock.RUnlock()
+	return _pageParts
+}
 
 // GitVersion defines git version of the server
 var GitVersion string
@@ -41,7 +61,8 @@ var StartTime time.Time
 // CMSAuth structure to create CMS Auth headers
 var CMSAuth cmsauth.CMSAuth
 
-var wMgr *WMStatsManager
+// wSources represents wmstats sources of the server
+var wSources *WMStatsSources
 
 // helper function to provide base path of URL
 func basePath(api string) string {
@@ -65,6 +86,9 @@ func Handlers() *mux.Router {
 
 	// aux APIs used by server
 	router.HandleFunc(basePath("/healthz"), StatusHandler).Methods("GET")
+	router.HandleFunc(basePath("/healthz/live"), LiveHandler).Methods("GET")
+	router.HandleFunc(basePath("/healthz/ready"), ReadyHandler).Methods("GET")
+	router.HandleFunc(basePath("/status"), ServerStatusHandler).Methods("GET")
 	router.HandleFunc(basePath("/metrics"), MetricsHandler).Methods("GET")
 
 	// main page
@@ -72,33 +96,108 @@ func Handlers() *mux.Router {
 	router.HandleFunc(basePath("/agents"), AgentsHandler).Methods("GET")
 	router.HandleFunc(basePath("/errorlogs"), ErrorLogsHandler).Methods("GET")
 	router.HandleFunc(basePath("/workflows"), WorkflowsHandler).Methods("GET")
+	router.HandleFunc(basePath("/search"), SearchHandler).Methods("GET")
+	router.HandleFunc(basePath("/search/suggest"), SuggestHandler).Methods("GET")
+	router.HandleFunc(basePath("/sources"), SourcesHandler).Methods("GET")
 	router.HandleFunc(basePath("/"), MainHandler).Methods("GET")
 
+	// for all requests assign request ID
+	router.Use(requestIDMiddleware)
+	// for all requests start trace span
+	router.Use(tracingMiddleware)
+	// for all requests account them in server metrics, router does not
+	// apply middlewares to requests which do not match any route
+	router.Use(metricsMiddleware)
+	router.NotFoundHandler = metricsMiddleware(http.NotFoundHandler())
+	router.MethodNotAllowedHandler = metricsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
 	// for all requests
-	router.Use(logging.LoggingMiddleware)
+	if LogFormat == "json" {
+		router.Use(accessLogMiddleware)
+	} else {
+		router.Use(logging.LoggingMiddleware)
+	}
 	// for all requests perform first auth/authz action
 	router.Use(authMiddleware)
 
 	// use limiter middleware to slow down clients
 	router.Use(limitMiddleware)
+	// trace request handlers
+	router.Use(handlerTracingMiddleware)
 	return router
 }
 
 // helper function to run as go-routine to update WMStats cache
-func updateWMStatsCache(wmgr *WMStatsManager, ctx context.Context) {
+func updateWMStatsCache(sources *WMStatsSources, ctx context.Context) {
+	var pushed time.Time
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		default:
-			time.Sleep(time.Duration(5) * time.Second)
-			if wmgr != nil {
-				wmgr.update()
+		case <-time.After(time.Duration(5) * time.Second):
+			if sources != nil && sources.due() {
+				pushed = refreshWMStats(ctx, sources, pushed)
 			}
 		}
 	}
 }
 
+// helper function to refresh wmstats sources, it rebuilds wmstats info of
+// refreshed sources and pushes their metrics. Every refresh has its own ID
+// and trace to follow its log records and its spans.
+func refreshWMStats(ctx context.Context, sources *WMStatsSources, pushed time.Time) time.Time {
+	ctx = withRequestID(ctx, newRequestID())
+	ctx, span := startSpan(ctx, "refresh")
+	defer span.End()
+	sources.update(ctx)
+	// decode and aggregate refreshed data in background rather than on first
+	// request, including data of merged namespace
+	labels := sources.Labels
+	if len(labels) > 1 {
+		labels = append(labels[:len(labels):len(labels)], MergedSource)
+	}
+	for _, label := range labels {
+		wmstatsInfo(ctx, label, nil)
+	}
+	return pushSourcesMetrics(ctx, sources, pushed)
+}
+
+// helper function to initialize common parts of server pages
+func initPageTemplates() {
+	tmpl := make(TmplRecord)
+	tmpl["Base"] = Config.Base
+	tmpl["ServerInfo"] = ServerInfo
+	tmpl["Time"] = time.Now()
+	parts := PageParts{
+		Top:    template.HTML(tmplPage(context.Background(), "top.tmpl", tmpl)),
+		Bottom: template.HTML(tmplPage(context.Background(), "bottom.tmpl", tmpl)),
+		Header: template.HTML(tmplPage(context.Background(), "header.tmpl", tmpl)),
+		Footer: template.HTML(tmplPage(context.Background(), "footer.tmpl", tmpl)),
+	}
+	_pagePartsLock.Lock()
+	_pageParts = parts
+	_pagePartsLock.Unlock()
+}
+
+// helper function to setup parameters of upstream requests from server configuration
+func initFetchParameters() {
+	TIMEOUT = Config.FetchTimeout
+	// zero number of retries is valid value, i.e. it disables retries
+	if Config.FetchRetries != nil && *Config.FetchRetries >= 0 {
+		FetchRetries = *Config.FetchRetries
+	}
+	if Config.FetchBackoff > 0 {
+		FetchBackoff = time.Duration(Config.FetchBackoff) * time.Second
+	}
+	if Config.CircuitThreshold > 0 {
+		CircuitThreshold = Config.CircuitThreshold
+	}
+	if Config.CircuitCooldown > 0 {
+		CircuitCooldown = time.Duration(Config.CircuitCooldown) * time.Second
+	}
+}
+
 // Server represents main web server for service
 //gocyclo:ignore
 func Server(configFile string) {
@@ -111,7 +210,8 @@ func Server(configFile string) {
 	if Config.Verbose > 0 {
 		log.SetFlags(log.Lshortfile)
 	}
-	log.SetOutput(new(logging.LogWriter))
+	// text logs are prefixed by time stamp while JSON ones are written as is
+	var logOut, logRaw io.Writer = new(logging.LogWriter), os.Stdout
 	if Config.LogFile != "" {
 		logName := Config.LogFile
 		hostname := os.Getenv("HOSTNAME")
@@ -131,12 +231,22 @@ func Server(configFile string) {
 		//         rl, err := rotatelogs.New(Config.LogFile + "-%Y%m%d")
 		rl, err := rotatelogs.New(logName + "-%Y%m%d")
 		if err == nil {
-			rotlogs := logging.RotateLogWriter{RotateLogs: rl}
-			log.SetOutput(rotlogs)
+			logOut = logging.RotateLogWriter{RotateLogs: rl}
+			logRaw = rl
 		} else {
 			log.Println("ERROR: unable to get rotatelogs", err)
 		}
 	}
+	logLevel := Config.LogLevel
+	if logLevel == "" && Config.Verbose > 2 {
+		logLevel = "debug"
+	}
+	if Config.LogFormat == "json" {
+		logOut = logRaw
+	}
+	if err := setupLogging(Config.LogFormat, logLevel, logOut); err != nil {
+		log.Fatal(err)
+	}
 	if err != nil {
 		log.Printf("Unable to parse, time: %v, config: %v\n", time.Now(), configFile)
 	}
@@ -148,28 +258,67 @@ func Server(configFile string) {
 	// initialize limiter
 	initLimiter(Config.LimiterPeriod)
 
-	// initialize templates
-	tmpl := make(TmplRecord)
-	tmpl["Base"] = Config.Base
-	tmpl["ServerInfo"] = ServerInfo
-	tmpl["Time"] = time.Now()
-	_top = template.HTML(tmplPage("top.tmpl", tmpl))
-	_bottom = template.HTML(tmplPage("bottom.tmpl", tmpl))
-	_header = template.HTML(tmplPage("header.tmpl", tmpl))
-	_footer = template.HTML(tmplPage("footer.tmpl", tmpl))
+	// initialize templates from static content
+	sfs := staticFS()
+	_templates, err = NewTemplates(templatesFS(sfs))
+	if err != nil {
+		log.Fatal(err)
+	}
+	_templates.OnReload = initPageTemplates
+	initPageTemplates()
 
 	// static handlers
 	for _, dir := range []string{"js", "css", "images"} {
 		m := fmt.Sprintf("%s/%s/", Config.Base, dir)
-		d := fmt.Sprintf("%s/%s", Config.StaticDir, dir)
-		http.Handle(m, http.StripPrefix(m, http.FileServer(http.Dir(d))))
+		d, err := fs.Sub(sfs, dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		http.Handle(m, http.StripPrefix(m, http.FileServer(http.FS(d))))
+	}
+
+	// setup upstream requests parameters
+	initFetchParameters()
+	if Config.StaleThreshold > 0 {
+		StaleThreshold = Config.StaleThreshold
+	}
+	if Config.MetricsInterval > 0 {
+		MetricsInterval = time.Duration(Config.MetricsInterval) * time.Second
+	}
+	initMetrics(Config.MetricsPrefix)
+	PushGateway = Config.PushGateway
+	RemoteWrite = Config.RemoteWrite
+	if Config.PushJob != "" {
+		PushJob = Config.PushJob
+	}
+	if Config.PushTimeout > 0 {
+		PushTimeout = time.Duration(Config.PushTimeout) * time.Second
 	}
 
-	// setup WMStatsManager to handle our cache
-	wMgr = NewWMStatsManager(Config.AccessURI)
+	// setup tracing of requests and wmstats refreshes
+	shutdownTracing, err := initTracing(Config.OTLPEndpoint, Config.TraceSampleRatio)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// setup wmstats sources to handle our cache
+	var renew []int64
+	if Config.RenewInterval > 0 {
+		renew = append(renew, Config.RenewInterval)
+	}
+	wSources, err = NewWMStatsSources(Config.WMStatsSources(), Config.MergeSources, renew...)
+	if err != nil {
+		log.Fatal(err)
+	}
 	ctx0, cancel0 := context.WithCancel(context.Background())
 	defer cancel0()
-	go updateWMStatsCache(wMgr, ctx0)
+	go updateWMStatsCache(wSources, ctx0)
+	go updateMetrics(ctx0, MetricsInterval)
+
+	// watch templates in development mode
+	if Config.TemplatesWatch {
+		go _templates.Watch(ctx0, time.Duration(2)*time.Second)
+	}
 
 	// define our HTTP server
 	http.Handle("/", Handlers())
@@ -217,6 +366,9 @@ func Server(configFile string) {
 	<-httpDone
 	log.Print("HTTP server stopped")
 
+	// cancel updates of wmstats sources and their upstream requests
+	cancel0()
+
 	// add extra timeout for shutdown service stuff
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer func() {
@@ -226,5 +378,9 @@ func Server(configFile string) {
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("Server Shutdown Failed:%+v", err)
 	}
+	// flush pending traces
+	if err := shutdownTracing(ctx); err != nil {
+		log.Println("ERROR: unable to shutdown tracing", err)
+	}
 	log.Print("HTTP server exited properly")
 }
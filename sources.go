@@ -0,0 +1,199 @@
+package main
+
+// wmstats sources module provides support of multiple upstream wmstats sources
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MergedSource defines label of namespace which merges all sources
+const MergedSource = "all"
+
+// Source represents labeled wmstats source
+type Source struct {
+	Label string `json:"label"` // source label, e.g. production or testbed
+	URI   string `json:"uri"`   // source URI, either URL, file, directory or glob pattern
+}
+
+// SourceStatus represents health status of wmstats source
+type SourceStatus struct {
+	Label   string    `json:"label"`
+	URI     string    `json:"uri"`
+	Healthy bool      `json:"healthy"`
+	Error   string    `json:"error,omitempty"`
+	Updated time.Time `json:"updated"` // time of last successful update
+	Checked time.Time `json:"checked"` // time of last successful check of source
+	Size    int       `json:"size"`    // size of wmstats data in bytes
+	// refresh counters
+	Refreshes   uint64 `json:"refreshes"`            // total number of refreshes
+	Failures    uint64 `json:"failures"`             // total number of failed refreshes
+	Consecutive uint64 `json:"consecutive_failures"` // number of consecutive failed refreshes
+}
+
+// WMStatsSources manages set of labeled wmstats sources
+type WMStatsSources struct {
+	Labels   []string                   // ordered list of source labels
+	Managers map[string]*WMStatsManager // wmstats managers of sources
+	Merge    bool                       // use merged namespace by default
+	merged   *WMStatsManager            // cached data of merged namespace
+	nmerged  int                        // number of sources in merged namespace
+	mutex    sync.Mutex
+}
+
+// NewWMStatsSources creates set of wmstats sources
+func NewWMStatsSources(sources []Source, merge bool, renew ...int64) (*WMStatsSources, error) {
+	s := &WMStatsSources{Managers: make(map[string]*WMStatsManager), Merge: merge}
+	for _, src := range sources {
+		if src.Label == "" || src.Label == MergedSource {
+			return nil, fmt.Errorf("invalid source label '%s' of %s", src.Label, src.URI)
+		}
+		if _, ok := s.Managers[src.Label]; ok {
+			return nil, fmt.Errorf("duplicate source label '%s'", src.Label)
+		}
+		mgr := NewWMStatsManager(src.URI, renew...)
+		mgr.Label = src.Label
+		s.Labels = append(s.Labels, src.Label)
+		s.Managers[src.Label] = mgr
+	}
+	if len(s.Labels) == 0 {
+		return nil, fmt.Errorf("no wmstats sources are provided")
+	}
+	return s, nil
+}
+
+// helper function to parse comma separated list of label=URI sources
+func parseSources(value string) ([]Source, error) {
+	var sources []Source
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		pair := strings.SplitN(item, "=", 2)
+		if len(pair) != 2 || pair[0] == "" || pair[1] == "" {
+			return nil, fmt.Errorf("invalid source '%s', it should be in label=URI form", item)
+		}
+		sources = append(sources, Source{Label: pair[0], URI: pair[1]})
+	}
+	return sources, nil
+}
+
+// update concurrently updates all sources whose cache is expired, remote
+// requests are canceled along with given context
+func (s *WMStatsSources) update(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, label := range s.Labels {
+		wg.Add(1)
+		go func(mgr *WMStatsManager) {
+			defer wg.Done()
+			mgr.updateContext(ctx)
+		}(s.Managers[label])
+	}
+	wg.Wait()
+}
+
+// helper function to check if any of wmstats sources should be refreshed
+func (s *WMStatsSources) due() bool {
+	for _, label := range s.Labels {
+		if s.Managers[label].expired() {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function to resolve given namespace, empty namespace means default
+// one, i.e. merged namespace if sources should be merged and first source
+// otherwise
+func (s *WMStatsSources) label(label string) string {
+	if label == "" {
+		label = s.Labels[0]
+		if s.Merge && len(s.Labels) > 1 {
+			label = MergedSource
+		}
+	}
+	return label
+}
+
+// Manager returns snapshot of wmstats manager of given namespace. The namespace is
+// either source label or "all" for merged data of all sources. Empty
+// namespace means default one, i.e. merged namespace if sources should
+// be merged and first source otherwise.
+func (s *WMStatsSources) Manager(label string) (*WMStatsManager, error) {
+	label = s.label(label)
+	if label != MergedSource {
+		if mgr, ok := s.Managers[label]; ok {
+			return mgr.Snapshot(), nil
+		}
+		return nil, fmt.Errorf("%w '%s'", ErrUnknownSource, label)
+	}
+
+	// merge data of all sources which have data
+	var updated time.Time
+	var ttl int64
+	payloads := make(map[string][]byte)
+	for _, l := range s.Labels {
+		mgr := s.Managers[l].Snapshot()
+		if len(mgr.Data) == 0 {
+			continue
+		}
+		payloads[l] = mgr.Data
+		if mgr.Updated.After(updated) {
+			updated = mgr.Updated
+		}
+		if ttl == 0 || mgr.TTL < ttl {
+			ttl = mgr.TTL
+		}
+	}
+	mgr := &WMStatsManager{Label: MergedSource, URI: MergedSource, Updated: updated, TTL: ttl}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.merged != nil && s.merged.Updated.Equal(updated) && len(payloads) == s.nmerged {
+		mgr.Data = s.merged.Data
+		return mgr, nil
+	}
+	if len(payloads) > 0 {
+		data, err := mergeRecords(payloads)
+		if err != nil {
+			return nil, err
+		}
+		mgr.Data = data
+	}
+	s.merged = &WMStatsManager{Label: MergedSource, URI: MergedSource, Updated: updated, Data: mgr.Data}
+	s.nmerged = len(payloads)
+	return mgr, nil
+}
+
+// Status returns health status of all sources
+func (s *WMStatsSources) Status() []SourceStatus {
+	var out []SourceStatus
+	for _, label := range s.Labels {
+		mgr := s.Managers[label].Snapshot()
+		status := SourceStatus{
+			Label:   label,
+			URI:     mgr.URI,
+			Healthy: mgr.Error == nil && len(mgr.Data) > 0,
+			Updated: mgr.Updated,
+			Checked: mgr.Checked,
+			Size:    len(mgr.Data),
+
+			Refreshes:   mgr.Refreshes,
+			Failures:    mgr.Failures,
+			Consecutive: mgr.Consecutive,
+		}
+		if mgr.Error != nil {
+			status.Error = mgr.Error.Error()
+		} else if len(mgr.Data) == 0 {
+			status.Error = "wmstats data is not yet available"
+		}
+		out = append(out, status)
+	}
+	return out
+}
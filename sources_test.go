@@ -0,0 +1,88 @@
+package main
+
+// sources_test module provides unit tests of wmstats sources
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// helper function to create wmstats sources of test files
+func testSources(t *testing.T) *WMStatsSources {
+	record := func(name string) string {
+		return `"` + name + `":{"RequestName":"` + name + `","Campaign":"Run2022A","RequestStatus":"running-open"}`
+	}
+	prod := writeTestFile(t, t.TempDir(), "prod.json", []byte(`{"result":[{`+record("wf1")+`}]}`))
+	testbed := writeTestFile(t, t.TempDir(), "testbed.json", []byte(`{"result":[{`+record("wf2")+`}]}`))
+	sources, err := NewWMStatsSources([]Source{{"prod", prod}, {"testbed", testbed}}, true, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sources
+}
+
+// TestWMStatsSourcesConcurrency tests that wmstats data and info are read
+// while sources are refreshed, it should be run with -race flag
+func TestWMStatsSourcesConcurrency(t *testing.T) {
+	sources := testSources(t)
+	wSources = sources
+	defer func() { wSources = nil }()
+	sources.update(context.Background())
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			refreshWMStats(context.Background(), sources, StartTime)
+		}
+	}()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				for _, label := range []string{"", "prod", "testbed", MergedSource} {
+					if _, err := wmstatsInfo(context.Background(), label, nil); err != nil {
+						t.Errorf("%s: %v", label, err)
+					}
+					if _, err := dataFreshness(label); err != nil {
+						t.Errorf("%s: %v", label, err)
+					}
+				}
+				sources.Status()
+				degradedReasons("")
+			}
+		}()
+	}
+	wg.Wait()
+
+	tests := []struct {
+		label     string
+		workflows int
+	}{
+		{"prod", 1},
+		{"testbed", 1},
+		{MergedSource, 2},
+		{"", 2},
+	}
+	for _, tt := range tests {
+		info, err := wmstatsInfo(context.Background(), tt.label, nil)
+		if err != nil {
+			t.Errorf("%s: %v", tt.label, err)
+			continue
+		}
+		if len(info.Workflows) != tt.workflows {
+			t.Errorf("%s: %d workflows, expected %d", tt.label, len(info.Workflows), tt.workflows)
+		}
+	}
+	for _, status := range sources.Status() {
+		if !status.Healthy || status.Refreshes < 2 {
+			t.Errorf("wrong status of source %+v", status)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+// static module provides server static content
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"embed"
+	"io/fs"
+	"log"
+	"os"
+)
+
+// default static content (templates, css, js and images) shipped with the binary
+//
+//go:embed static
+var embedStatic embed.FS
+
+// helper function to check if given path is existing directory
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// staticFS returns file system of server static content, the on-disk
+// Config.StaticDir overrides static content embedded into the binary
+func staticFS() fs.FS {
+	if Config.StaticDir != "" {
+		if isDir(Config.StaticDir) {
+			return os.DirFS(Config.StaticDir)
+		}
+		log.Printf("WARNING: static dir '%s' does not exist, use embedded static content", Config.StaticDir)
+	}
+	fsys, err := fs.Sub(embedStatic, "static")
+	if err != nil {
+		// should never happen since static directory is embedded at build time
+		log.Fatal(err)
+	}
+	return fsys
+}
+
+// templatesFS returns file system of server templates, the on-disk
+// Config.Templates directory overrides templates from given static content
+func templatesFS(sfs fs.FS) fs.FS {
+	if Config.Templates != "" {
+		if isDir(Config.Templates) {
+			return os.DirFS(Config.Templates)
+		}
+		log.Printf("WARNING: templates dir '%s' does not exist, use static templates", Config.Templates)
+	}
+	fsys, err := fs.Sub(sfs, "templates")
+	if err != nil {
+		log.Fatal(err)
+	}
+	return fsys
+}
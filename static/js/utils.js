@@ -1,58 +1,26 @@
-// sort table
-// https://www.w3schools.com/howto/howto_js_sort_table.asp
-// tag: table tag id
-// n: table column id
-function sortTable(tag, n) {
-  var table, rows, switching, i, x, y, shouldSwitch, dir, switchcount = 0;
-  table = document.getElementById(tag);
-  switching = true;
-  // Set the sorting direction to ascending:
-  dir = "asc";
-  /* Make a loop that will continue until
-  no switching has been done: */
-  while (switching) {
-    // Start by saying: no switching is done:
-    switching = false;
-    rows = table.rows;
-    /* Loop through all table rows (except the
-    first, which contains table headers): */
-    for (i = 1; i < (rows.length - 1); i++) {
-      // Start by saying there should be no switching:
-      shouldSwitch = false;
-      /* Get the two elements you want to compare,
-      one from current row and one from the next: */
-      x = rows[i].getElementsByTagName("TD")[n];
-      y = rows[i + 1].getElementsByTagName("TD")[n];
-      /* Check if the two rows should switch place,
-      based on the direction, asc or desc: */
-      if (dir == "asc") {
-        if (x.innerHTML.toLowerCase() > y.innerHTML.toLowerCase()) {
-          // If so, mark as a switch and break the loop:
-          shouldSwitch = true;
-          break;
-        }
-      } else if (dir == "desc") {
-        if (x.innerHTML.toLowerCase() < y.innerHTML.toLowerCase()) {
-          // If so, mark as a switch and break the loop:
-          shouldSwitch = true;
-          break;
-        }
-      }
-    }
-    if (shouldSwitch) {
-      /* If a switch has been marked, make the switch
-      and mark that a switch has been done: */
-      rows[i].parentNode.insertBefore(rows[i + 1], rows[i]);
-      switching = true;
-      // Each time a switch is done, increase this count by 1:
-      switchcount ++;
-    } else {
-      /* If no switching has been done AND the direction is "asc",
-      set the direction to "desc" and run the while loop again. */
-      if (switchcount == 0 && dir == "asc") {
-        dir = "desc";
-        switching = true;
-      }
-    }
+// fetch type-ahead suggestions and fill given datalist
+// base: server base path
+// value: current value of input field
+// tag: datalist id
+// source: optional wmstats source
+function suggest(base, value, tag, source) {
+  if (value.length < 2) {
+    return;
   }
+  var url = base + "/search/suggest?q=" + encodeURIComponent(value);
+  if (source) {
+    url += "&source=" + encodeURIComponent(source);
+  }
+  fetch(url)
+    .then(function(response) { return response.json(); })
+    .then(function(entries) {
+      var list = document.getElementById(tag);
+      list.innerHTML = "";
+      entries.forEach(function(e) {
+        var opt = document.createElement("option");
+        opt.value = e.value;
+        opt.label = e.type + " (" + e.count + ")";
+        list.appendChild(opt);
+      });
+    });
 }
@@ -0,0 +1,131 @@
+package main
+
+// stats metrics module provides wmstats data metrics in prometheus format
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsStats defines list of wmstats attributes exported as metrics
+var MetricsStats = []string{"campaign", "site", "cmssw", "agent"}
+
+// statsCollector exposes wmstats data metrics of all sources of the server.
+// Every numeric column of attribute tables becomes a gauge labeled by
+// source and attribute value, e.g. wmstats_site_running{source,site}.
+// The set of metrics depends on wmstats data and therefore the collector
+// is unchecked, i.e. it does not describe its metrics upfront.
+type statsCollector struct {
+	up        *prometheus.Desc
+	duration  *prometheus.Desc
+	size      *prometheus.Desc
+	workflows *prometheus.Desc
+	refreshed *prometheus.Desc
+	age       *prometheus.Desc
+	failures  *prometheus.Desc
+}
+
+// helper function to create wmstats data metrics collector
+func newStatsCollector() *statsCollector {
+	source := []string{"source"}
+	return &statsCollector{
+		up:        prometheus.NewDesc("source_up", "1 if last refresh of wmstats source succeeded and its data is available", source, nil),
+		duration:  prometheus.NewDesc("refresh_duration_seconds", "duration of last refresh of wmstats source", source, nil),
+		size:      prometheus.NewDesc("payload_size_bytes", "size of wmstats data of the source", source, nil),
+		workflows: prometheus.NewDesc("workflows", "number of workflows in wmstats data of the source", source, nil),
+		refreshed: prometheus.NewDesc("last_refresh_timestamp_seconds", "unix time of last successful refresh of wmstats source", source, nil),
+		age:       prometheus.NewDesc("data_age_seconds", "age of wmstats data of the source", source, nil),
+		failures:  prometheus.NewDesc("refresh_failures_total", "total number of failed refreshes of wmstats source", source, nil),
+	}
+}
+
+// Describe implements prometheus.Collector interface
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+}
+
+// helper function to collect metrics of given wmstats table
+func collectTableMetrics(ch chan<- prometheus.Metric, stats, source string, t *Table) {
+	if len(t.Columns) == 0 {
+		return
+	}
+	key := t.Columns[0].Name
+	for k, c := range t.Columns {
+		if k == 0 {
+			continue
+		}
+		name, help := c.Name, fmt.Sprintf("%s of %s", c.Title, stats)
+		if isRateColumn(stats, c.Name) {
+			help += " in percents"
+		} else if c.Name == "failure_rate" {
+			// failure rate of some stats is number of failed jobs
+			name, help = "failed_jobs", fmt.Sprintf("number of failed jobs of %s", stats)
+		}
+		desc := prometheus.NewDesc(fmt.Sprintf("%s_%s", stats, name), help, []string{"source", key}, nil)
+		for _, row := range t.Rows {
+			if val, ok := toFloat(row[k]); ok {
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, val, source, formatValue(row[0]))
+			}
+		}
+	}
+}
+
+// helper function to collect aggregated metrics of wmstats info of given source
+func collectInfoMetrics(ch chan<- prometheus.Metric, source string, info *WMStatsInfo) {
+	for _, stats := range MetricsStats {
+		collectTableMetrics(ch, stats, source, info.Table(stats))
+	}
+	desc := prometheus.NewDesc("site_failed_jobs", "number of failed jobs of site", []string{"source", "site"}, nil)
+	for site, s := range info.SiteStatsMap {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(s.FailJobs), source, site)
+	}
+}
+
+// infoCollector exposes aggregated metrics of wmstats info of given sources,
+// it is used to push metrics computed outside of the server
+type infoCollector map[string]*WMStatsInfo
+
+// Describe implements prometheus.Collector interface
+func (c infoCollector) Describe(ch chan<- *prometheus.Desc) {
+}
+
+// Collect implements prometheus.Collector interface
+func (c infoCollector) Collect(ch chan<- prometheus.Metric) {
+	for source, info := range c {
+		collectInfoMetrics(ch, source, info)
+	}
+}
+
+// Collect implements prometheus.Collector interface, it only reads cached
+// wmstats info since the info is built by refresh of wmstats sources
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	if wSources == nil {
+		return
+	}
+	for _, label := range wSources.Labels {
+		if info := cachedWMStatsInfo(label); info != nil {
+			collectInfoMetrics(ch, label, info)
+		}
+	}
+	for _, s := range wSources.Status() {
+		f, _ := dataFreshness(s.Label)
+		up := 0.0
+		if s.Healthy {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, up, s.Label)
+		ch <- prometheus.MustNewConstMetric(c.duration, prometheus.GaugeValue, f.Duration, s.Label)
+		ch <- prometheus.MustNewConstMetric(c.size, prometheus.GaugeValue, float64(s.Size), s.Label)
+		ch <- prometheus.MustNewConstMetric(c.workflows, prometheus.GaugeValue, float64(f.Workflows), s.Label)
+		refreshed := 0.0
+		if !s.Updated.IsZero() {
+			refreshed = float64(s.Updated.Unix())
+		}
+		ch <- prometheus.MustNewConstMetric(c.refreshed, prometheus.GaugeValue, refreshed, s.Label)
+		ch <- prometheus.MustNewConstMetric(c.age, prometheus.GaugeValue, float64(f.Age), s.Label)
+		ch <- prometheus.MustNewConstMetric(c.failures, prometheus.CounterValue, float64(s.Failures), s.Label)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+// statsmetrics_test module provides unit tests of wmstats data metrics
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// helper function to gather metrics of wmstats data keyed by metric name
+func gatherStatsMetrics(t *testing.T) map[string]*dto.MetricFamily {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newStatsCollector())
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]*dto.MetricFamily)
+	for _, mf := range families {
+		out[mf.GetName()] = mf
+	}
+	return out
+}
+
+// TestStatsCollector tests that collector exports cached wmstats info and
+// failed jobs of agents and cmssw releases as counts
+func TestStatsCollector(t *testing.T) {
+	fname := writeTestFile(t, t.TempDir(), "wmstats.json", []byte(`{"result":[]}`))
+	sources, err := NewWMStatsSources([]Source{{"metrics", fname}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources.update(context.Background())
+	wSources = sources
+	defer func() {
+		wSources = nil
+		_wmstatsInfoLock.Lock()
+		delete(_wmstatsInfo, "metrics")
+		_wmstatsInfoLock.Unlock()
+	}()
+
+	// collector does not build wmstats info which is not yet cached
+	metrics := gatherStatsMetrics(t)
+	if _, ok := metrics["source_up"]; !ok {
+		t.Errorf("no source metrics")
+	}
+	if _, ok := metrics["agent_requests"]; ok {
+		t.Errorf("metrics of wmstats info which is not built")
+	}
+	if cachedWMStatsInfo("metrics") != nil {
+		t.Fatalf("wmstats info is built by collector")
+	}
+
+	_wmstatsInfoLock.Lock()
+	_wmstatsInfo["metrics"] = wmstatsInfoCache{Info: &WMStatsInfo{
+		SiteStatsMap:  SiteStatsMap{"T1_US_FNAL": {Requests: 2, FailureRate: 12.5}},
+		AgentStatsMap: AgentStatsMap{"vocms0250": {Requests: 3, FailureRate: 40}},
+		CMSSWStatsMap: CMSSWStatsMap{"CMSSW_12_4_0": {Requests: 1, FailureRate: 7}},
+	}}
+	_wmstatsInfoLock.Unlock()
+	metrics = gatherStatsMetrics(t)
+	tests := []struct {
+		name  string
+		label string
+		value float64
+		help  string
+	}{
+		{"site_failure_rate", "T1_US_FNAL", 12.5, "in percents"},
+		{"agent_failed_jobs", "vocms0250", 40, "number of failed jobs of agent"},
+		{"cmssw_failed_jobs", "CMSSW_12_4_0", 7, "number of failed jobs of cmssw"},
+		{"agent_requests", "vocms0250", 3, ""},
+	}
+	for _, tt := range tests {
+		mf, ok := metrics[tt.name]
+		if !ok {
+			t.Errorf("no metric %s", tt.name)
+			continue
+		}
+		if !strings.Contains(mf.GetHelp(), tt.help) {
+			t.Errorf("%s: wrong help %q", tt.name, mf.GetHelp())
+		}
+		m := mf.GetMetric()[0]
+		// labels are sorted by name, i.e. stats attribute goes before source
+		var labels []string
+		for _, l := range m.GetLabel() {
+			labels = append(labels, l.GetValue())
+		}
+		if strings.Join(labels, ",") != tt.label+",metrics" || m.GetGauge().GetValue() != tt.value {
+			t.Errorf("%s: wrong metric %v", tt.name, m)
+		}
+	}
+	for _, name := range []string{"agent_failure_rate", "cmssw_failure_rate"} {
+		if _, ok := metrics[name]; ok {
+			t.Errorf("number of failed jobs is exported as %s", name)
+		}
+	}
+}
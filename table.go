@@ -0,0 +1,218 @@
+package main
+
+// wmstats table module
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Column represents table column definition
+type Column struct {
+	Name  string                    // column name used by sort and columns parameters
+	Title string                    // column title
+	Link  func(value string) string // optional function to build a link for column value
+}
+
+// TableRow represents single row of the table
+type TableRow []interface{}
+
+// Table represents generic table of wmstats data
+type Table struct {
+	Name    string     // table name, e.g. site-stats
+	Columns []Column   // table columns
+	Rows    []TableRow // table rows
+	Total   int        // total number of rows before pagination
+	Options TableOptions
+	// Freshness represents optional freshness of wmstats data of the table
+	Freshness *Freshness
+}
+
+// TableOptions represents sorting, pagination and column selection options
+type TableOptions struct {
+	Sort    string   // column name to sort by
+	Order   string   // sort order, either asc or desc
+	Limit   int      // max number of rows to return, 0 means no limit
+	Offset  int      // number of rows to skip
+	Columns []string // list of columns to return, empty list means all columns
+}
+
+// NewTableOptions creates table options from given set of parameters
+func NewTableOptions(sortBy, order string, limit, offset int, columns string) TableOptions {
+	opts := TableOptions{Sort: sortBy, Order: strings.ToLower(order), Limit: limit, Offset: offset}
+	if opts.Order != "desc" {
+		opts.Order = "asc"
+	}
+	if opts.Limit < 0 {
+		opts.Limit = 0
+	}
+	if opts.Offset < 0 {
+		opts.Offset = 0
+	}
+	for _, c := range strings.Split(columns, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			opts.Columns = append(opts.Columns, c)
+		}
+	}
+	return opts
+}
+
+// helper function to get table options from HTTP query parameters
+func tableOptions(query url.Values) TableOptions {
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	return NewTableOptions(query.Get("sort"), query.Get("order"), limit, offset, query.Get("columns"))
+}
+
+// Values returns table options as URL query parameters
+func (o TableOptions) Values() url.Values {
+	vals := url.Values{}
+	if o.Sort != "" {
+		vals.Set("sort", o.Sort)
+		vals.Set("order", o.Order)
+	}
+	if o.Limit > 0 {
+		vals.Set("limit", fmt.Sprintf("%d", o.Limit))
+	}
+	if o.Offset > 0 {
+		vals.Set("offset", fmt.Sprintf("%d", o.Offset))
+	}
+	if len(o.Columns) > 0 {
+		vals.Set("columns", strings.Join(o.Columns, ","))
+	}
+	return vals
+}
+
+// helper function to find column index by its name
+func (t *Table) columnIndex(name string) int {
+	for i, c := range t.Columns {
+		if strings.EqualFold(c.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// helper function to compare two table values, it returns negative
+// number if a < b, zero if they are equal and positive number otherwise
+func compareValues(a, b interface{}) int {
+	switch va := a.(type) {
+	case int:
+		if vb, ok := b.(int); ok {
+			return va - vb
+		}
+	case float64:
+		if vb, ok := b.(float64); ok {
+			if va < vb {
+				return -1
+			} else if va > vb {
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+}
+
+// Apply applies given options to the table and returns new table.
+// Rows are always sorted (by default using the first column) to
+// provide deterministic ordering.
+func (t *Table) Apply(opts TableOptions) *Table {
+	out := &Table{Name: t.Name, Total: len(t.Rows), Options: opts}
+
+	// sort rows, the sort option is normalized to the matched column name
+	idx := t.columnIndex(opts.Sort)
+	if idx < 0 {
+		idx = 0
+		out.Options.Sort = ""
+	} else {
+		out.Options.Sort = t.Columns[idx].Name
+	}
+	rows := make([]TableRow, len(t.Rows))
+	copy(rows, t.Rows)
+	sort.SliceStable(rows, func(i, j int) bool {
+		c := compareValues(rows[i][idx], rows[j][idx])
+		if c == 0 && idx != 0 {
+			// use first column to resolve ties
+			c = compareValues(rows[i][0], rows[j][0])
+		}
+		if opts.Order == "desc" {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	// apply pagination
+	if opts.Offset > len(rows) {
+		rows = rows[:0]
+	} else {
+		rows = rows[opts.Offset:]
+	}
+	if opts.Limit > 0 && len(rows) > opts.Limit {
+		rows = rows[:opts.Limit]
+	}
+
+	// select columns
+	var cols []int
+	for _, name := range opts.Columns {
+		if i := t.columnIndex(name); i >= 0 {
+			cols = append(cols, i)
+		}
+	}
+	if len(cols) == 0 {
+		for i := range t.Columns {
+			cols = append(cols, i)
+		}
+	}
+	for _, i := range cols {
+		out.Columns = append(out.Columns, t.Columns[i])
+	}
+	for _, row := range rows {
+		var r TableRow
+		for _, i := range cols {
+			r = append(r, row[i])
+		}
+		out.Rows = append(out.Rows, r)
+	}
+	return out
+}
+
+// Records returns table rows as list of records keyed by column names
+func (t *Table) Records() []map[string]interface{} {
+	records := []map[string]interface{}{}
+	for _, row := range t.Rows {
+		rec := make(map[string]interface{})
+		for i, c := range t.Columns {
+			rec[c.Name] = row[i]
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+// MarshalJSON implements json.Marshaler interface
+func (t *Table) MarshalJSON() ([]byte, error) {
+	var columns []string
+	for _, c := range t.Columns {
+		columns = append(columns, c.Name)
+	}
+	rec := map[string]interface{}{
+		"name":    t.Name,
+		"columns": columns,
+		"total":   t.Total,
+		"offset":  t.Options.Offset,
+		"limit":   t.Options.Limit,
+		"rows":    t.Records(),
+	}
+	if t.Freshness != nil {
+		rec["freshness"] = t.Freshness
+	}
+	return json.Marshal(rec)
+}
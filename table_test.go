@@ -0,0 +1,121 @@
+package main
+
+// table_test module provides unit tests of wmstats tables
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// helper function to create test table
+func testTable() *Table {
+	return &Table{
+		Name: "site-stats",
+		Columns: []Column{
+			{Name: "site", Title: "Site"},
+			{Name: "requests", Title: "Requests"},
+			{Name: "failure_rate", Title: "Failure Rate"},
+		},
+		Rows: []TableRow{
+			{"T2_CH_CERN", 5, 1.5},
+			{"T1_US_FNAL", 10, 0.5},
+			{"T2_US_MIT", 5, 20.0},
+			{"T1_DE_KIT", 1, 0.0},
+		},
+	}
+}
+
+// helper function to get values of first column of the table
+func firstColumn(t *Table) []string {
+	var out []string
+	for _, row := range t.Rows {
+		out = append(out, row[0].(string))
+	}
+	return out
+}
+
+// TestTableApply tests sorting, pagination and column selection of tables
+func TestTableApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    TableOptions
+		rows    []string
+		sort    string
+		columns int
+	}{
+		{"default sort", NewTableOptions("", "", 0, 0, ""),
+			[]string{"T1_DE_KIT", "T1_US_FNAL", "T2_CH_CERN", "T2_US_MIT"}, "", 3},
+		{"unknown column", NewTableOptions("bla", "desc", 0, 0, ""),
+			[]string{"T2_US_MIT", "T2_CH_CERN", "T1_US_FNAL", "T1_DE_KIT"}, "", 3},
+		// ties are resolved by the first column
+		{"sort by int", NewTableOptions("requests", "asc", 0, 0, ""),
+			[]string{"T1_DE_KIT", "T2_CH_CERN", "T2_US_MIT", "T1_US_FNAL"}, "requests", 3},
+		{"sort by int desc", NewTableOptions("requests", "DESC", 0, 0, ""),
+			[]string{"T1_US_FNAL", "T2_US_MIT", "T2_CH_CERN", "T1_DE_KIT"}, "requests", 3},
+		{"sort by float", NewTableOptions("failure_rate", "desc", 0, 0, ""),
+			[]string{"T2_US_MIT", "T2_CH_CERN", "T1_US_FNAL", "T1_DE_KIT"}, "failure_rate", 3},
+		{"sort option is normalized", NewTableOptions("Requests", "desc", 0, 0, ""),
+			[]string{"T1_US_FNAL", "T2_US_MIT", "T2_CH_CERN", "T1_DE_KIT"}, "requests", 3},
+		{"limit", NewTableOptions("", "", 2, 0, ""),
+			[]string{"T1_DE_KIT", "T1_US_FNAL"}, "", 3},
+		{"limit and offset", NewTableOptions("", "", 2, 3, ""),
+			[]string{"T2_US_MIT"}, "", 3},
+		{"offset beyond rows", NewTableOptions("", "", 0, 10, ""),
+			nil, "", 3},
+		{"negative limit and offset", NewTableOptions("", "", -1, -1, ""),
+			[]string{"T1_DE_KIT", "T1_US_FNAL", "T2_CH_CERN", "T2_US_MIT"}, "", 3},
+		{"columns", NewTableOptions("", "", 1, 0, "site, failure_rate,bla"),
+			[]string{"T1_DE_KIT"}, "", 2},
+	}
+	for _, tt := range tests {
+		out := testTable().Apply(tt.opts)
+		if rows := firstColumn(out); !reflect.DeepEqual(rows, tt.rows) {
+			t.Errorf("%s: rows %v, expected %v", tt.name, rows, tt.rows)
+		}
+		if out.Total != 4 {
+			t.Errorf("%s: total %d, expected 4", tt.name, out.Total)
+		}
+		if out.Options.Sort != tt.sort {
+			t.Errorf("%s: sort option %q, expected %q", tt.name, out.Options.Sort, tt.sort)
+		}
+		if len(out.Columns) != tt.columns {
+			t.Errorf("%s: %d columns, expected %d", tt.name, len(out.Columns), tt.columns)
+		}
+		for _, row := range out.Rows {
+			if len(row) != len(out.Columns) {
+				t.Errorf("%s: row %v does not match columns", tt.name, row)
+			}
+		}
+	}
+
+	// original table is not modified
+	tab := testTable()
+	tab.Apply(NewTableOptions("requests", "desc", 1, 0, "site"))
+	if !reflect.DeepEqual(tab, testTable()) {
+		t.Errorf("table is modified by Apply")
+	}
+}
+
+// TestHTMLTableViewSort tests sort order shown in table headers
+func TestHTMLTableViewSort(t *testing.T) {
+	query := url.Values{"sort": {"Requests"}, "order": {"asc"}}
+	tab := testTable().Apply(tableOptions(query))
+	view := NewHTMLTableView(tab, "/wmstats", query)
+	for _, h := range view.Headers {
+		order := ""
+		if h.Title == "Requests" {
+			order = "asc"
+		}
+		if h.Order != order {
+			t.Errorf("column %s: order %q, expected %q", h.Title, h.Order, order)
+		}
+	}
+	expected := "/wmstats?order=desc&sort=requests"
+	if link := view.Headers[1].Link; link != expected {
+		t.Errorf("wrong sort link %s, expected %s", link, expected)
+	}
+}
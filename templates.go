@@ -7,66 +7,153 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"html/template"
-	"path/filepath"
+	"io/fs"
+	"log"
+	"strings"
+	"sync"
+	"time"
 )
 
 // TmplRecord represent template record
 type TmplRecord map[string]interface{}
 
-// consume list of templates and release their full path counterparts
-func fileNames(tdir string, filenames ...string) []string {
-	flist := []string{}
-	for _, fname := range filenames {
-		flist = append(flist, filepath.Join(tdir, fname))
+// tmplFuncMap represents functions available in server templates
+var tmplFuncMap = template.FuncMap{
+	// The name "oddFunc" is what the function will be called in the template text.
+	"oddFunc": func(i int) bool {
+		if i%2 == 0 {
+			return true
+		}
+		return false
+	},
+	// The name "inListFunc" is what the function will be called in the template text.
+	"inListFunc": func(a string, list []string) bool {
+		check := 0
+		for _, b := range list {
+			if b == a {
+				check += 1
+			}
+		}
+		if check != 0 {
+			return true
+		}
+		return false
+	},
+}
+
+// Templates represents registry of parsed server templates
+type Templates struct {
+	FS       fs.FS  // templates file system
+	OnReload func() // optional function to call after templates are reloaded
+
+	mutex   sync.RWMutex
+	tmpl    *template.Template
+	modTime time.Time
+	files   string // names of loaded templates
+}
+
+// global templates registry
+var _templates *Templates
+
+// NewTemplates creates templates registry and parses all templates
+// found in given file system
+func NewTemplates(fsys fs.FS) (*Templates, error) {
+	t := &Templates{FS: fsys}
+	if err := t.Load(); err != nil {
+		return nil, err
 	}
-	return flist
+	return t, nil
 }
 
-// parse template with given data
-func parseTmpl(tdir, tmpl string, data interface{}) string {
-	buf := new(bytes.Buffer)
-	filenames := fileNames(tdir, tmpl)
-	funcMap := template.FuncMap{
-		// The name "oddFunc" is what the function will be called in the template text.
-		"oddFunc": func(i int) bool {
-			if i%2 == 0 {
-				return true
-			}
-			return false
-		},
-		// The name "inListFunc" is what the function will be called in the template text.
-		"inListFunc": func(a string, list []string) bool {
-			check := 0
-			for _, b := range list {
-				if b == a {
-					check += 1
-				}
-			}
-			if check != 0 {
-				return true
-			}
-			return false
-		},
+// helper function to find latest modification time of templates along
+// with their names, the names are used to detect added or deleted templates
+func (t *Templates) lastModified() (time.Time, string, error) {
+	var modTime time.Time
+	files, err := fs.Glob(t.FS, "*.tmpl")
+	if err != nil {
+		return modTime, "", err
 	}
-	t := template.Must(template.New(tmpl).Funcs(funcMap).ParseFiles(filenames...))
-	err := t.Execute(buf, data)
+	for _, fname := range files {
+		fi, err := fs.Stat(t.FS, fname)
+		if err != nil {
+			return modTime, "", err
+		}
+		if fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
+	}
+	return modTime, strings.Join(files, ","), nil
+}
+
+// Load parses all templates from templates directory
+func (t *Templates) Load() error {
+	modTime, files, err := t.lastModified()
 	if err != nil {
-		panic(err)
+		return err
 	}
-	return buf.String()
+	tmpl, err := template.New("wmstats").Funcs(tmplFuncMap).ParseFS(t.FS, "*.tmpl")
+	if err != nil {
+		return fmt.Errorf("unable to parse templates: %w", err)
+	}
+	t.mutex.Lock()
+	t.tmpl = tmpl
+	t.modTime = modTime
+	t.files = files
+	t.mutex.Unlock()
+	return nil
 }
 
-// Templates structure
-type Templates struct {
-	html string
+// Render renders given template with provided data
+func (t *Templates) Render(name string, data interface{}) (string, error) {
+	t.mutex.RLock()
+	tmpl := t.tmpl
+	t.mutex.RUnlock()
+	if tmpl == nil {
+		return "", fmt.Errorf("templates are not loaded")
+	}
+	buf := new(bytes.Buffer)
+	if err := tmpl.ExecuteTemplate(buf, name, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
 }
 
-// Tmpl method for ServerTemplates structure
-func (q Templates) Tmpl(tdir, tfile string, tmplData map[string]interface{}) string {
-	if q.html != "" {
-		return q.html
+// Watch periodically checks templates and reloads them when any of
+// them is changed, added or deleted, it is intended for development mode with on-disk templates
+func (t *Templates) Watch(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+			modTime, files, err := t.lastModified()
+			if err != nil {
+				log.Println("ERROR: unable to check templates", err)
+				continue
+			}
+			t.mutex.RLock()
+			changed := modTime.After(t.modTime) || files != t.files
+			t.mutex.RUnlock()
+			if !changed {
+				continue
+			}
+			if err := t.Load(); err != nil {
+				// keep previous templates if new ones can't be parsed
+				// and wait for next change of templates
+				log.Println("ERROR: unable to reload templates", err)
+				t.mutex.Lock()
+				t.modTime = modTime
+				t.files = files
+				t.mutex.Unlock()
+				continue
+			}
+			log.Println("reload templates")
+			if t.OnReload != nil {
+				t.OnReload()
+			}
+		}
 	}
-	q.html = parseTmpl(Config.Templates, tfile, tmplData)
-	return q.html
 }
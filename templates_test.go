@@ -0,0 +1,156 @@
+package main
+
+// templates_test module provides unit tests of server templates
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestTemplatesEscaping tests that user provided data is escaped by server templates
+func TestTemplatesEscaping(t *testing.T) {
+	tmpls, err := NewTemplates(templatesFS(staticFS()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	xss := `<script>alert("x")</script>`
+	query := url.Values{"filters": {xss}, "source": {`"><img src=x onerror=alert(1)>`}}
+	tab := &Table{
+		Name: "site-stats",
+		Columns: []Column{
+			{Name: "site", Title: "Site", Link: workflowsLink("site")},
+			{Name: "requests", Title: "Requests"},
+		},
+		Rows: []TableRow{{xss, 1}},
+	}
+	tests := []struct {
+		tmpl     string
+		data     TmplRecord
+		expected []string // expected escaped fragments
+	}{
+		{"table.tmpl", TmplRecord{"Table": NewHTMLTableView(tab.Apply(TableOptions{}), "/wmstats", query)},
+			[]string{"&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;", "%3Cscript%3E"}},
+		{"main.tmpl", TmplRecord{"TitleKey": "campaign", "TitleValue": xss, "Degraded": []string{xss},
+			"AppliedFilters": map[string]string{"campaign": xss}},
+			[]string{"&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;"}},
+		{"filters.tmpl", TmplRecord{"Base": "/wmstats", "Query": xss, "Source": query.Get("source")},
+			[]string{"&#34;&gt;&lt;img src=x onerror=alert(1)&gt;"}},
+		{"search.tmpl", TmplRecord{"Base": "/wmstats", "Query": xss, "Source": `');alert('x`},
+			[]string{"&lt;script&gt;", `'\u0027);alert(\u0027x'`, "&#39;);alert(&#39;x"}},
+		{"searchresults.tmpl", TmplRecord{"Query": xss},
+			[]string{"No results found for <span class=\"alert is-focus\">&lt;script&gt;"}},
+	}
+	for _, tt := range tests {
+		page, err := tmpls.Render(tt.tmpl, tt.data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.tmpl, err)
+			continue
+		}
+		if strings.Contains(page, "<script>") || strings.Contains(page, "<img") {
+			t.Errorf("%s: unescaped data in page\n%s", tt.tmpl, page)
+		}
+		for _, s := range tt.expected {
+			if !strings.Contains(page, s) {
+				t.Errorf("%s: page does not contain %s\n%s", tt.tmpl, s, page)
+			}
+		}
+	}
+
+	// unknown templates are reported as errors
+	if _, err := tmpls.Render("bla.tmpl", nil); err == nil {
+		t.Errorf("no error for unknown template")
+	}
+}
+
+// TestTemplatesWatch tests reload of changed, added and deleted templates
+func TestTemplatesWatch(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a.tmpl", []byte("a1"))
+	writeTestFile(t, dir, "b.tmpl", []byte("b1"))
+	tmpls, err := NewTemplates(os.DirFS(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloads := make(chan bool, 10)
+	tmpls.OnReload = func() { reloads <- true }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tmpls.Watch(ctx, 10*time.Millisecond)
+
+	// helper function to wait for reload of templates
+	reload := func(name string) {
+		select {
+		case <-reloads:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: templates are not reloaded", name)
+		}
+	}
+	tests := []struct {
+		name   string
+		change func()
+		tmpl   string
+		page   string // empty page means template does not exist
+	}{
+		{"changed template", func() {
+			fname := writeTestFile(t, dir, "a.tmpl", []byte("a2"))
+			mtime := time.Now().Add(time.Minute)
+			os.Chtimes(fname, mtime, mtime)
+		}, "a.tmpl", "a2"},
+		{"deleted template", func() { os.Remove(filepath.Join(dir, "b.tmpl")) }, "b.tmpl", ""},
+		// added template may be older than already loaded ones
+		{"added template", func() {
+			fname := writeTestFile(t, dir, "c.tmpl", []byte("c1"))
+			mtime := time.Now().Add(-time.Hour)
+			os.Chtimes(fname, mtime, mtime)
+		}, "c.tmpl", "c1"},
+	}
+	for _, tt := range tests {
+		tt.change()
+		reload(tt.name)
+		page, err := tmpls.Render(tt.tmpl, nil)
+		if tt.page == "" && err == nil {
+			t.Errorf("%s: template %s is still loaded", tt.name, tt.tmpl)
+		}
+		if tt.page != "" && page != tt.page {
+			t.Errorf("%s: got %q, expected %q, error %v", tt.name, page, tt.page, err)
+		}
+	}
+}
+
+// TestPageParts tests that common parts of server pages are re-rendered
+// while they are used by handlers, it should be run with -race flag
+func TestPageParts(t *testing.T) {
+	tmpls, err := NewTemplates(templatesFS(staticFS()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(tmpls *Templates) { _templates = tmpls }(_templates)
+	_templates = tmpls
+	initPageTemplates()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			initPageTemplates()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			if parts := pageParts(); parts.Top == "" || parts.Footer == "" {
+				t.Errorf("empty parts of server pages")
+			}
+		}
+	}()
+	wg.Wait()
+}
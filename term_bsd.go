@@ -0,0 +1,15 @@
+//go:build darwin || freebsd || netbsd || openbsd
+
+package main
+
+// wmstats terminal module, BSD specific definitions
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import "golang.org/x/sys/unix"
+
+const (
+	ioctlGetTermios = unix.TIOCGETA
+	ioctlSetTermios = unix.TIOCSETA
+)
@@ -0,0 +1,15 @@
+//go:build linux
+
+package main
+
+// wmstats terminal module, linux specific definitions
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import "golang.org/x/sys/unix"
+
+const (
+	ioctlGetTermios = unix.TCGETS
+	ioctlSetTermios = unix.TCSETS
+)
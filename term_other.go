@@ -0,0 +1,23 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package main
+
+// wmstats terminal module, stubs for unsupported platforms
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"errors"
+	"runtime"
+)
+
+// makeRaw is not supported on this platform
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("terminal raw mode is not supported on " + runtime.GOOS)
+}
+
+// terminalSize is not supported on this platform
+func terminalSize(fd int) (int, int, error) {
+	return 0, 0, errors.New("terminal size is not supported on " + runtime.GOOS)
+}
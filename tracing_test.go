@@ -0,0 +1,177 @@
+package main
+
+// tracing_test module provides unit tests of request and refresh spans
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// helper function to record spans of the server tracer
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	orig := tracer
+	tracer = tp.Tracer("github.com/vkuznet/wmstats")
+	t.Cleanup(func() { tracer = orig })
+	return sr
+}
+
+// helper function to get tree of recorded spans, i.e. sorted list of
+// "parent > child" pairs, root spans have empty parent. All spans should
+// belong to single trace.
+func spanTree(t *testing.T, spans []sdktrace.ReadOnlySpan) []string {
+	names := make(map[trace.SpanID]string)
+	traces := make(map[trace.TraceID]bool)
+	for _, s := range spans {
+		names[s.SpanContext().SpanID()] = s.Name()
+		traces[s.SpanContext().TraceID()] = true
+	}
+	if len(traces) != 1 {
+		t.Errorf("spans belong to %d traces", len(traces))
+	}
+	var out []string
+	for _, s := range spans {
+		out = append(out, names[s.Parent().SpanID()]+" > "+s.Name())
+	}
+	sort.Strings(out)
+	// remove duplicates, e.g. spans of rendered templates
+	var tree []string
+	for i, pair := range out {
+		if i == 0 || out[i-1] != pair {
+			tree = append(tree, pair)
+		}
+	}
+	return tree
+}
+
+// helper function to get value of string attribute of given span
+func spanAttribute(s sdktrace.ReadOnlySpan, key attribute.Key) string {
+	for _, kv := range s.Attributes() {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+// helper function to create wmstats sources of tracing tests and clean up
+// cache of their wmstats info
+func tracingSources(t *testing.T, sources []Source) *WMStatsSources {
+	s, err := NewWMStatsSources(sources, true, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wSources = s
+	t.Cleanup(func() {
+		wSources = nil
+		_wmstatsInfoLock.Lock()
+		defer _wmstatsInfoLock.Unlock()
+		for _, label := range append(s.Labels, MergedSource) {
+			delete(_wmstatsInfo, label)
+		}
+	})
+	return s
+}
+
+// TestRequestSpans tests spans of HTTP request and their nesting
+func TestRequestSpans(t *testing.T) {
+	tmpls, err := NewTemplates(templatesFS(staticFS()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(tmpls *Templates) { _templates = tmpls }(_templates)
+	_templates = tmpls
+	initLimiter("100-S")
+	record := `"wf1":{"RequestName":"wf1","Campaign":"Run2022A","RequestStatus":"running-open"}`
+	fname := writeTestFile(t, t.TempDir(), "tracing.json", []byte(`{"result":[{`+record+`}]}`))
+	sources := tracingSources(t, []Source{{"tracing", fname}})
+	sources.update(context.Background())
+
+	sr := recordSpans(t)
+	r := httptest.NewRequest("GET", "/search?q=wf1", nil)
+	r.Header.Set(RequestIDHeader, "req-trace")
+	w := httptest.NewRecorder()
+	Handlers().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("wrong status %d: %s", w.Code, w.Body.String())
+	}
+
+	// wmstats info is built on first request within handler span
+	expected := []string{
+		" > GET /search",
+		"GET /search > auth",
+		"GET /search > handler",
+		"GET /search > limiter",
+		"handler > aggregate",
+		"handler > decode",
+		"handler > render",
+	}
+	spans := sr.Ended()
+	if tree := spanTree(t, spans); !reflect.DeepEqual(tree, expected) {
+		t.Errorf("got spans\n%s\nexpected\n%s", strings.Join(tree, "\n"), strings.Join(expected, "\n"))
+	}
+	for _, s := range spans {
+		if s.Parent().IsValid() && spanAttribute(s, "wmstats.request_id") != "req-trace" {
+			t.Errorf("span %s has no request ID", s.Name())
+		}
+		if s.Name() == "GET /search" && s.SpanKind() != trace.SpanKindServer {
+			t.Errorf("wrong kind %v of request span", s.SpanKind())
+		}
+	}
+}
+
+// TestRefreshSpans tests spans of refresh of wmstats sources and their nesting
+func TestRefreshSpans(t *testing.T) {
+	defer func(token string) { Token = token }(Token)
+	Token = "test-token"
+	srv := testUpstream(t, `"v1"`, time.Now().UTC().Format(http.TimeFormat))
+	fname := writeTestFile(t, t.TempDir(), "tracing.json", []byte(`{"result":[]}`))
+	sources := tracingSources(t, []Source{{"tracing-file", fname}, {"tracing-url", srv.URL + "/data"}})
+
+	sr := recordSpans(t)
+	refreshWMStats(context.Background(), sources, time.Now())
+
+	// data of sources and of merged namespace is decoded and aggregated
+	// within refresh span, upstream requests are nested in fetch span
+	expected := []string{
+		" > refresh",
+		"fetch > GET",
+		"refresh > aggregate",
+		"refresh > decode",
+		"refresh > refresh source",
+		"refresh source > fetch",
+		"refresh source > read",
+		"refresh source > validate",
+	}
+	spans := sr.Ended()
+	if tree := spanTree(t, spans); !reflect.DeepEqual(tree, expected) {
+		t.Errorf("got spans\n%s\nexpected\n%s", strings.Join(tree, "\n"), strings.Join(expected, "\n"))
+	}
+	decoded := make(map[string]bool)
+	for _, s := range spans {
+		if s.Name() == "decode" {
+			decoded[spanAttribute(s, "wmstats.source")] = true
+		}
+		if s.Name() == "GET" && s.SpanKind() != trace.SpanKindClient {
+			t.Errorf("wrong kind %v of upstream request span", s.SpanKind())
+		}
+	}
+	if !reflect.DeepEqual(decoded, map[string]bool{"tracing-file": true, "tracing-url": true, MergedSource: true}) {
+		t.Errorf("wrong decoded namespaces %v", decoded)
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+// wmstats tui module provides interactive terminal UI to browse wmstats data
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// TUIViews defines list of top level views of terminal UI
+var TUIViews = []string{"campaign", "site", "cmssw", "agent"}
+
+// TUI keys
+const (
+	keyUp        = "up"
+	keyDown      = "down"
+	keyLeft      = "left"
+	keyRight     = "right"
+	keyPageUp    = "pgup"
+	keyPageDown  = "pgdown"
+	keyEnter     = "enter"
+	keyEscape    = "esc"
+	keyBackspace = "backspace"
+	keyCtrlC     = "ctrl-c"
+)
+
+// tuiView represents single view of terminal UI, i.e. stats table, list of
+// workflows associated with given attribute or per-site workflow breakdown
+type tuiView struct {
+	Stats  string // campaign, site, cmssw, agent, workflows or workflow
+	Key    string // drill down attribute, e.g. campaign
+	Value  string // drill down attribute value
+	Sort   int    // index of sort column
+	Desc   bool   // descending sort order
+	Filter string // regular expression to filter rows by their first column
+	Cursor int    // index of selected row
+	Offset int    // index of first visible row
+}
+
+// TUI represents state of interactive terminal UI
+type TUI struct {
+	Options CliOptions   // CLI options
+	Info    *WMStatsInfo // wmstats data
+	Fresh   Freshness    // freshness of wmstats data
+	Views   []*tuiView   // stack of views, last one is shown
+	Width   int          // terminal width
+	Height  int          // terminal height
+	Input   *string      // filter input buffer, nil if we are not in input mode
+	Message string       // status message
+	table   *Table       // table of current view
+}
+
+// helper function to get current view
+func (t *TUI) view() *tuiView {
+	return t.Views[len(t.Views)-1]
+}
+
+// helper function to build table of given view
+func (t *TUI) viewTable(v *tuiView) *Table {
+	var tab *Table
+	switch v.Stats {
+	case "workflows":
+		workflows, _ := t.Info.WorkflowsBy(v.Key, v.Value)
+		tab = workflowTable(workflows)
+	case "workflow":
+		tab = workflowSitesTable(t.Info.Workflows[v.Value])
+	default:
+		tab = t.Info.Table(v.Stats)
+	}
+	if v.Filter != "" {
+		if pat, err := regexp.Compile(v.Filter); err == nil {
+			var rows []TableRow
+			for _, row := range tab.Rows {
+				if pat.MatchString(fmt.Sprintf("%v", row[0])) {
+					rows = append(rows, row)
+				}
+			}
+			tab.Rows = rows
+		}
+	}
+	order := "asc"
+	if v.Desc {
+		order = "desc"
+	}
+	if v.Sort >= len(tab.Columns) {
+		v.Sort = 0
+	}
+	return tab.Apply(NewTableOptions(tab.Columns[v.Sort].Name, order, 0, 0, ""))
+}
+
+// helper function to refresh table of current view
+func (t *TUI) refresh() {
+	v := t.view()
+	t.table = t.viewTable(v)
+	if v.Cursor >= len(t.table.Rows) {
+		v.Cursor = len(t.table.Rows) - 1
+	}
+	if v.Cursor < 0 {
+		v.Cursor = 0
+	}
+}
+
+// helper function to reload wmstats data
+func (t *TUI) reload() error {
+	info, fresh, err := t.Options.loadInfo()
+	if err != nil {
+		return err
+	}
+	t.Info = info
+	t.Fresh = fresh
+	t.refresh()
+	return nil
+}
+
+// helper function to drill down from selected row of current view
+func (t *TUI) drill() {
+	v := t.view()
+	if len(t.table.Rows) == 0 {
+		return
+	}
+	value := fmt.Sprintf("%v", t.table.Rows[v.Cursor][0])
+	var next *tuiView
+	switch v.Stats {
+	case "workflows":
+		next = &tuiView{Stats: "workflow", Key: "workflow", Value: value}
+	case "workflow":
+		t.Message = "no further drill down for site breakdown"
+		return
+	default:
+		next = &tuiView{Stats: "workflows", Key: v.Stats, Value: value}
+	}
+	t.Views = append(t.Views, next)
+	t.refresh()
+}
+
+// helper function to go back to previous view
+func (t *TUI) back() {
+	if len(t.Views) > 1 {
+		t.Views = t.Views[:len(t.Views)-1]
+		t.refresh()
+	}
+}
+
+// helper function to move cursor by given number of rows
+func (t *TUI) move(delta int) {
+	v := t.view()
+	v.Cursor += delta
+	if v.Cursor >= len(t.table.Rows) {
+		v.Cursor = len(t.table.Rows) - 1
+	}
+	if v.Cursor < 0 {
+		v.Cursor = 0
+	}
+}
+
+// helper function to get number of table rows which fit into the terminal
+func (t *TUI) pageSize() int {
+	// title, help, header and status lines
+	if size := t.Height - 5; size > 0 {
+		return size
+	}
+	return 1
+}
+
+// Handle handles given key and returns true if TUI should quit
+func (t *TUI) Handle(key string) bool {
+	t.Message = ""
+	if t.Input != nil {
+		switch key {
+		case keyEnter:
+			v := t.view()
+			if _, err := regexp.Compile(*t.Input); err != nil {
+				t.Message = fmt.Sprintf("invalid filter: %v", err)
+			} else {
+				v.Filter = *t.Input
+				v.Cursor, v.Offset = 0, 0
+			}
+			t.Input = nil
+			t.refresh()
+		case keyEscape, keyCtrlC:
+			t.Input = nil
+		case keyBackspace:
+			if n := len(*t.Input); n > 0 {
+				_, size := utf8.DecodeLastRuneInString(*t.Input)
+				*t.Input = (*t.Input)[:n-size]
+			}
+		default:
+			if len([]rune(key)) == 1 && key != "\t" {
+				*t.Input += key
+			}
+		}
+		return false
+	}
+	v := t.view()
+	switch key {
+	case "q", keyCtrlC:
+		return true
+	case "1", "2", "3", "4":
+		idx := int(key[0] - '1')
+		t.Views = []*tuiView{{Stats: TUIViews[idx]}}
+		t.refresh()
+	case "\t":
+		if len(t.Views) == 1 {
+			idx := 0
+			for i, name := range TUIViews {
+				if name == v.Stats {
+					idx = (i + 1) % len(TUIViews)
+				}
+			}
+			t.Views = []*tuiView{{Stats: TUIViews[idx]}}
+			t.refresh()
+		}
+	case keyUp, "k":
+		t.move(-1)
+	case keyDown, "j":
+		t.move(1)
+	case keyPageUp:
+		t.move(-t.pageSize())
+	case keyPageDown, " ":
+		t.move(t.pageSize())
+	case keyLeft, "h":
+		if v.Sort > 0 {
+			v.Sort--
+			t.refresh()
+		}
+	case keyRight, "l":
+		if v.Sort < len(t.table.Columns)-1 {
+			v.Sort++
+			t.refresh()
+		}
+	case "s":
+		v.Desc = !v.Desc
+		t.refresh()
+	case "/":
+		input := v.Filter
+		t.Input = &input
+	case "c":
+		v.Filter = ""
+		t.refresh()
+	case keyEnter:
+		t.drill()
+	case keyEscape, keyBackspace:
+		t.back()
+	case "r":
+		if err := t.reload(); err != nil {
+			t.Message = err.Error()
+		} else {
+			t.Message = "wmstats data is reloaded"
+		}
+	}
+	return false
+}
+
+// Render renders current view to given writer
+func (t *TUI) Render(w io.Writer) {
+	v := t.view()
+	var out strings.Builder
+	out.WriteString(ansiClear)
+
+	// title line shows path of views
+	var path []string
+	for _, view := range t.Views {
+		if view.Value != "" {
+			path = append(path, fmt.Sprintf("%s=%s", view.Key, view.Value))
+		} else {
+			path = append(path, view.Stats)
+		}
+	}
+	title := fmt.Sprintf("wmstats: %s (%d rows)", strings.Join(path, " > "), len(t.table.Rows))
+	if v.Filter != "" {
+		title += fmt.Sprintf(", filter: %s", v.Filter)
+	}
+	fresh := t.Fresh.now()
+	title += fmt.Sprintf(", data of %s (%s ago)", fresh.Updated.Format("15:04:05"), formatAge(fresh.Age))
+	style := ansiBold
+	if fresh.Stale {
+		title += ", STALE"
+		style += ansiYellow
+	}
+	if fresh.Failed {
+		title += ", REFRESH FAILED"
+		style += ansiYellow
+	}
+	fmt.Fprintf(&out, "%s%s%s\r\n", style, truncateText(title, t.Width), ansiReset)
+	help := "1-4/tab: view  j/k: move  h/l: sort column  s: sort order  /: filter  c: clear  enter: drill down  esc: back  r: reload  q: quit"
+	fmt.Fprintf(&out, "%s\r\n", truncateText(help, t.Width))
+
+	// keep cursor within visible rows
+	size := t.pageSize()
+	if v.Cursor < v.Offset {
+		v.Offset = v.Cursor
+	}
+	if v.Cursor >= v.Offset+size {
+		v.Offset = v.Cursor - size + 1
+	}
+
+	r := NewTextRenderer(w)
+	r.Width = t.Width
+	r.Style = func(row, col int) string {
+		if row < 0 && col == v.Sort {
+			return ansiUnderline
+		}
+		if row == v.Cursor {
+			return ansiReverse
+		}
+		return ""
+	}
+	lines := r.Lines(t.table)
+	fmt.Fprintf(&out, "\r\n%s\r\n", lines[0])
+	for i := v.Offset; i < len(t.table.Rows) && i < v.Offset+size; i++ {
+		fmt.Fprintf(&out, "%s\r\n", lines[i+1])
+	}
+
+	// status line
+	if t.Input != nil {
+		fmt.Fprintf(&out, "filter (regexp): %s", *t.Input)
+	} else if t.Message != "" {
+		out.WriteString(truncateText(t.Message, t.Width))
+	}
+	io.WriteString(w, out.String())
+}
+
+// helper function to parse keys from terminal input
+func parseKeys(data []byte) []string {
+	var keys []string
+	seqs := map[string]string{
+		"\033[A": keyUp, "\033[B": keyDown, "\033[C": keyRight, "\033[D": keyLeft,
+		"\033OA": keyUp, "\033OB": keyDown, "\033OC": keyRight, "\033OD": keyLeft,
+		"\033[5~": keyPageUp, "\033[6~": keyPageDown,
+	}
+	s := string(data)
+	for len(s) > 0 {
+		matched := false
+		if s[0] == '\033' {
+			for seq, key := range seqs {
+				if strings.HasPrefix(s, seq) {
+					keys = append(keys, key)
+					s = s[len(seq):]
+					matched = true
+					break
+				}
+			}
+			if !matched && len(s) > 1 && s[1] == '[' {
+				// skip unknown escape sequence
+				i := 2
+				for i < len(s) && (s[i] < 0x40 || s[i] > 0x7e) {
+					i++
+				}
+				if i < len(s) {
+					i++
+				}
+				s = s[i:]
+				continue
+			}
+		}
+		if matched {
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+		if r == utf8.RuneError && size == 1 {
+			// skip invalid UTF-8 byte
+			continue
+		}
+		switch r {
+		case '\r', '\n':
+			keys = append(keys, keyEnter)
+		case '\033':
+			keys = append(keys, keyEscape)
+		case 127, '\b':
+			keys = append(keys, keyBackspace)
+		case 3:
+			keys = append(keys, keyCtrlC)
+		default:
+			keys = append(keys, string(r))
+		}
+	}
+	return keys
+}
+
+// cliTUI provides interactive terminal UI to browse wmstats data
+func cliTUI(opts CliOptions, stats string) error {
+	if err := opts.Setup(); err != nil {
+		return err
+	}
+	if opts.Verbose == 0 {
+		// do not mix log messages with the screen
+		log.SetOutput(io.Discard)
+	}
+	tui := &TUI{Options: opts, Views: []*tuiView{{Stats: stats}}}
+	if err := tui.reload(); err != nil {
+		return err
+	}
+	fd := int(os.Stdin.Fd())
+	restore, err := makeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("unable to setup terminal: %w", err)
+	}
+	defer restore()
+	fmt.Print(ansiHideCursor)
+	defer fmt.Print(ansiClear + ansiShowCursor)
+
+	buf := make([]byte, 64)
+	for {
+		tui.Width, tui.Height, err = terminalSize(int(os.Stdout.Fd()))
+		if err != nil || tui.Width == 0 || tui.Height == 0 {
+			tui.Width, tui.Height = 120, 40
+		}
+		tui.Render(os.Stdout)
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return err
+		}
+		for _, key := range parseKeys(buf[:n]) {
+			if tui.Handle(key) {
+				return nil
+			}
+		}
+	}
+}
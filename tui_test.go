@@ -0,0 +1,121 @@
+package main
+
+// tui_test module provides unit tests of terminal UI
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestParseKeys tests parsing of terminal input into TUI keys
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		keys []string
+	}{
+		{"empty input", nil, nil},
+		{"plain keys", []byte("q/1"), []string{"q", "/", "1"}},
+		{"control keys", []byte{'\r', '\n', 127, '\b', 3}, []string{keyEnter, keyEnter, keyBackspace, keyBackspace, keyCtrlC}},
+		{"arrows", []byte("\033[A\033[B\033OC\033OD"), []string{keyUp, keyDown, keyRight, keyLeft}},
+		{"pages", []byte("\033[5~\033[6~"), []string{keyPageUp, keyPageDown}},
+		{"escape", []byte("\033"), []string{keyEscape}},
+		{"unknown escape sequence", []byte("\033[1;5Aj"), []string{"j"}},
+		{"multibyte rune", []byte("é"), []string{"é"}},
+		{"invalid byte", []byte{0xff}, nil},
+		{"invalid bytes between keys", []byte{'j', 0xff, 0xfe, 'k'}, []string{"j", "k"}},
+		// multibyte rune split by read buffer
+		{"truncated rune", []byte("é")[:1], nil},
+		{"truncated escape sequence", []byte("\033["), nil},
+	}
+	for _, tt := range tests {
+		if keys := parseKeys(tt.data); !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("%s: keys %q, expected %q", tt.name, keys, tt.keys)
+		}
+	}
+}
+
+// helper function to create TUI of test wmstats data
+func testTUI(t *testing.T) *TUI {
+	record := func(name, campaign string) string {
+		return `"` + name + `":{"RequestName":"` + name + `","Campaign":"` + campaign + `","RequestStatus":"running-open"}`
+	}
+	data := `{"result":[{` + record("wf1", "Run2022A") + `,` + record("wf2", "Run2022A") + `,` + record("wf3", "Run2022B") + `}]}`
+	fname := writeTestFile(t, t.TempDir(), "wmstats.json", []byte(data))
+	tui := &TUI{Options: CliOptions{File: fname}, Views: []*tuiView{{Stats: "campaign"}}, Width: 120, Height: 40}
+	if err := tui.reload(); err != nil {
+		t.Fatal(err)
+	}
+	return tui
+}
+
+// TestTUIHandle tests handling of keys by TUI
+func TestTUIHandle(t *testing.T) {
+	tests := []struct {
+		name   string
+		keys   []string
+		quit   bool
+		stats  string // stats of current view
+		views  int    // number of views in stack
+		cursor int
+		filter string
+		rows   int
+	}{
+		{"no keys", nil, false, "campaign", 1, 0, "", 2},
+		{"quit", []string{"q"}, true, "campaign", 1, 0, "", 2},
+		{"ctrl-c", []string{keyCtrlC}, true, "campaign", 1, 0, "", 2},
+		{"cursor down", []string{keyDown}, false, "campaign", 1, 1, "", 2},
+		{"cursor stays within rows", []string{"j", "j", "j"}, false, "campaign", 1, 1, "", 2},
+		{"cursor up", []string{"j", "k", "k"}, false, "campaign", 1, 0, "", 2},
+		{"switch view", []string{"2"}, false, "site", 1, 0, "", 0},
+		{"next view", []string{"\t"}, false, "site", 1, 0, "", 0},
+		{"drill down", []string{keyEnter}, false, "workflows", 2, 0, "", 2},
+		{"drill down to workflow", []string{keyEnter, keyEnter}, false, "workflow", 3, 0, "", 0},
+		{"back", []string{keyEnter, keyEscape}, false, "campaign", 1, 0, "", 2},
+		{"back at top view", []string{keyBackspace}, false, "campaign", 1, 0, "", 2},
+		{"filter", []string{"/", "B", keyEnter}, false, "campaign", 1, 0, "B", 1},
+		{"filter with backspace", []string{"/", "B", keyBackspace, "A", keyEnter}, false, "campaign", 1, 0, "A", 1},
+		{"filter with multibyte rune", []string{"/", "A", "é", keyBackspace, keyEnter}, false, "campaign", 1, 0, "A", 1},
+		{"cancelled filter", []string{"/", "B", keyEscape}, false, "campaign", 1, 0, "", 2},
+		// quit keys are input of filter
+		{"quit key in filter", []string{"/", "q"}, false, "campaign", 1, 0, "", 2},
+		{"invalid filter", []string{"/", "(", keyEnter}, false, "campaign", 1, 0, "", 2},
+		{"clear filter", []string{"/", "B", keyEnter, "c"}, false, "campaign", 1, 0, "", 2},
+	}
+	for _, tt := range tests {
+		tui := testTUI(t)
+		quit := false
+		for _, key := range tt.keys {
+			quit = tui.Handle(key)
+		}
+		v := tui.view()
+		if quit != tt.quit {
+			t.Errorf("%s: quit %v, expected %v", tt.name, quit, tt.quit)
+		}
+		if v.Stats != tt.stats || len(tui.Views) != tt.views {
+			t.Errorf("%s: view %s of %d views, expected %s of %d", tt.name, v.Stats, len(tui.Views), tt.stats, tt.views)
+		}
+		if v.Cursor != tt.cursor || v.Filter != tt.filter || len(tui.table.Rows) != tt.rows {
+			t.Errorf("%s: cursor %d, filter %q, %d rows, expected %d, %q, %d",
+				tt.name, v.Cursor, v.Filter, len(tui.table.Rows), tt.cursor, tt.filter, tt.rows)
+		}
+	}
+
+	// invalid filter is reported to user
+	tui := testTUI(t)
+	for _, key := range []string{"/", "(", keyEnter} {
+		tui.Handle(key)
+	}
+	if tui.Message == "" || tui.Input != nil {
+		t.Errorf("invalid filter is not reported, message %q", tui.Message)
+	}
+
+	// sorting toggles order of current view
+	tui.Handle("s")
+	if !tui.view().Desc {
+		t.Error("sort order is not toggled")
+	}
+}
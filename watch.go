@@ -0,0 +1,151 @@
+package main
+
+// wmstats watch module provides live-refreshing terminal table
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ANSI escape sequences used by watch mode and terminal UI
+const (
+	ansiClear      = "\033[H\033[2J"
+	ansiHighlight  = "\033[1;33m"
+	ansiBold       = "\033[1m"
+	ansiUnderline  = "\033[4m"
+	ansiReverse    = "\033[7m"
+	ansiHideCursor = "\033[?25l"
+	ansiShowCursor = "\033[?25h"
+	ansiReset      = "\033[0m"
+)
+
+// helper function to build map of table cells keyed by row key and column name
+func tableCells(t *Table) map[string]string {
+	cells := make(map[string]string)
+	for _, row := range t.Rows {
+		key := fmt.Sprintf("%v", row[0])
+		for i, v := range row {
+			cells[key+"|"+t.Columns[i].Name] = fmt.Sprintf("%v", v)
+		}
+	}
+	return cells
+}
+
+// helper function to render table in text format with given renderer and
+// highlight cells whose values are different from previous ones, cells are
+// highlighted only if renderer uses colors
+func renderWatchTable(w io.Writer, r *TextRenderer, t *Table, prev map[string]string) {
+	if prev != nil && r.Color {
+		r.Style = func(row, col int) string {
+			if row < 0 {
+				return ""
+			}
+			key := fmt.Sprintf("%v|%s", t.Rows[row][0], t.Columns[col].Name)
+			if old, ok := prev[key]; !ok || old != fmt.Sprintf("%v", t.Rows[row][col]) {
+				return ansiHighlight
+			}
+			return ""
+		}
+	}
+	r.Render(w, t)
+}
+
+// cliWatch provides CLI interface to watch wmstats data, it re-reads
+// wmstats input on given interval and redraws the table in place
+func cliWatch(opts CliOptions, stats string, interval time.Duration) error {
+	if err := opts.Setup(); err != nil {
+		return err
+	}
+	// the table is redrawn in place, i.e. other formats make no sense
+	if strings.ToLower(opts.Format) != "table" {
+		return fmt.Errorf("watch mode supports only table format, format '%s' is not supported", opts.Format)
+	}
+	if opts.File == "" && opts.Sources == "" && opts.Server == "" {
+		return fmt.Errorf("wmstats input is not provided, please use -file, -sources or -server option")
+	}
+	if interval < time.Second {
+		return fmt.Errorf("watch interval should be at least 1s")
+	}
+	if opts.Verbose == 0 {
+		// do not mix log messages with the table
+		log.SetOutput(io.Discard)
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	var prev map[string]string
+	for {
+		// the table is redrawn in place and colored only on color terminals,
+		// otherwise iterations are separated by empty line
+		r := NewTextRenderer(os.Stdout)
+		var out strings.Builder
+		if r.Color {
+			out.WriteString(ansiClear)
+		} else if prev != nil {
+			out.WriteString("\n")
+		}
+		warning := func(msg interface{}) {
+			if r.Color {
+				fmt.Fprintf(&out, "%sWARNING: %v%s\n", ansiYellow, msg, ansiReset)
+			} else {
+				fmt.Fprintf(&out, "WARNING: %v\n", msg)
+			}
+		}
+		input := opts.File
+		if opts.Server != "" {
+			input = opts.Server
+		} else if opts.Sources != "" {
+			input = opts.Sources
+		}
+		fmt.Fprintf(&out, "wmstats %s every %v, input %s, last update %s (Ctrl-C to exit)\n\n",
+			stats, interval, input, time.Now().Format(time.RFC3339))
+		var t *Table
+		var err error
+		if opts.Server != "" {
+			params := url.Values{}
+			params.Set("stats", stats)
+			t, err = serverTable(opts, "/", params, emptyTable(stats))
+		} else {
+			// wmstats input is re-read on every iteration
+			var info *WMStatsInfo
+			var fresh Freshness
+			if info, fresh, err = opts.loadInfo(); err == nil {
+				t = info.Table(stats).Apply(opts.TableOptions())
+				t.Freshness = &fresh
+				if perr := opts.push(info); perr != nil {
+					warning(perr)
+				}
+			}
+		}
+		if err == nil && t.Freshness != nil {
+			fmt.Fprintf(&out, "%s\n", t.Freshness.String())
+			for _, msg := range t.Freshness.Warnings() {
+				warning(msg)
+			}
+			out.WriteString("\n")
+		}
+		if err == nil {
+			renderWatchTable(&out, r, t, prev)
+			prev = tableCells(t)
+		} else {
+			fmt.Fprintln(&out, "ERROR:", err)
+		}
+		fmt.Print(out.String())
+		select {
+		case <-sig:
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+// watch_test module provides unit tests of watch mode
+//
+// Copyright (c) 2022 - Valentin Kuznetsov <vkuznet@gmail.com>
+//
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCliWatchFormat tests that watch mode rejects non-table formats
+func TestCliWatchFormat(t *testing.T) {
+	for _, format := range []string{"json", "csv", "yaml", "markdown"} {
+		opts := CliOptions{File: "wmstats.json", Format: format, Retries: -1, Stale: -1}
+		err := cliWatch(opts, "site", time.Second)
+		if err == nil || !strings.Contains(err.Error(), "only table format") {
+			t.Errorf("%s: wrong error %v", format, err)
+		}
+	}
+}
+
+// TestRenderWatchTable tests highlighting of changed cells of watch table
+func TestRenderWatchTable(t *testing.T) {
+	tab := &Table{
+		Columns: []Column{{Name: "site", Title: "Site"}, {Name: "requests", Title: "Requests"}},
+		Rows:    []TableRow{{"T1_US_FNAL", 2}, {"T2_CH_CERN", 1}},
+	}
+	prev := map[string]string{
+		"T1_US_FNAL|site": "T1_US_FNAL", "T1_US_FNAL|requests": "1",
+		"T2_CH_CERN|site": "T2_CH_CERN", "T2_CH_CERN|requests": "1",
+	}
+	tests := []struct {
+		name        string
+		color       bool
+		prev        map[string]string
+		highlighted int
+	}{
+		{"first iteration", true, nil, 0},
+		{"changed cell", true, prev, 1},
+		{"unchanged table", true, tableCells(tab), 0},
+		// new rows are highlighted as a whole
+		{"new rows", true, map[string]string{}, 4},
+		{"no colors", false, prev, 0},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		renderWatchTable(&out, &TextRenderer{Color: tt.color}, tab, tt.prev)
+		if n := strings.Count(out.String(), ansiHighlight); n != tt.highlighted {
+			t.Errorf("%s: %d highlighted cells, expected %d\n%q", tt.name, n, tt.highlighted, out.String())
+		}
+		if !tt.color && strings.Contains(out.String(), "\033[") {
+			t.Errorf("%s: escape sequences without colors %q", tt.name, out.String())
+		}
+	}
+}
@@ -6,14 +6,17 @@ package main
 //
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"regexp"
+	"sort"
 	"time"
 
 	// data set
 	"github.com/fatih/set"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // WMStatsInfo represent wmstats info structure
@@ -26,21 +29,87 @@ type WMStatsInfo struct {
 	SiteWorkflows     WorkflowMap
 	CMSSWWorkflows    WorkflowMap
 	AgentWorkflows    WorkflowMap
+	PrepIDWorkflows   WorkflowMap
+	Workflows         map[string]WorkflowInfo
+	SearchIndex       *SearchIndex
 }
 
-// wmstats provide aggregated statistics
-func wmstats(wmgr *WMStatsManager, filters WMStatsFilters, verbose int) *WMStatsInfo {
+// Table returns table for given stats type, e.g. site, by default it
+// returns campaign table
+func (w *WMStatsInfo) Table(stats string) *Table {
+	switch stats {
+	case "agent":
+		return w.AgentStatsMap.Table()
+	case "site":
+		return w.SiteStatsMap.Table()
+	case "cmssw":
+		return w.CMSSWStatsMap.Table()
+	case "workflows":
+		var names []string
+		for name := range w.Workflows {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var workflows []Workflow
+		for _, name := range names {
+			winfo := w.Workflows[name]
+			workflows = append(workflows, Workflow{
+				Workflow:            winfo.Name,
+				Status:              winfo.State,
+				Type:                winfo.Type,
+				Priority:            winfo.Priority,
+				EstimatedCompletion: "N/A",
+			})
+		}
+		return workflowTable(workflows)
+	}
+	return w.CampaignStatsMap.Table()
+}
+
+// WorkflowsBy returns list of workflows associated with given attribute
+// (campaign, site, cmssw, agent or prepid) and its value
+func (w *WMStatsInfo) WorkflowsBy(key, value string) ([]Workflow, bool) {
+	var wmap WorkflowMap
+	switch key {
+	case "campaign":
+		wmap = w.CampaignWorkflows
+	case "site":
+		wmap = w.SiteWorkflows
+	case "cmssw":
+		wmap = w.CMSSWWorkflows
+	case "agent":
+		wmap = w.AgentWorkflows
+	case "prepid":
+		wmap = w.PrepIDWorkflows
+	}
+	workflows, ok := wmap[value]
+	return workflows, ok
+}
+
+// wmstats provide aggregated statistics, it returns ErrNoData if wmstats
+// data is not yet available and DataError if data can't be decoded
+func wmstats(ctx context.Context, wmgr *WMStatsManager, filters WMStatsFilters, verbose int) (*WMStatsInfo, error) {
 	time0 := time.Now()
 	// update our cacheAgentStatsMawmgr.update()
 	var wmstats WMStatsResults
 	if len(wmgr.Data) == 0 {
-		return nil
+		return nil, ErrNoData
 	}
+	_, span := startSpan(ctx, "decode",
+		attribute.String("wmstats.source", wmgr.Label),
+		attribute.Int("wmstats.size", len(wmgr.Data)))
 	err := json.Unmarshal(wmgr.Data, &wmstats)
 	if err != nil {
-		log.Fatal(err)
+		err = &DataError{URI: wmgr.URI, Err: err}
+		endSpan(span, err)
+		return nil, err
 	}
+	span.End()
 	data := wmstats.Result
+	_, span = startSpan(ctx, "aggregate",
+		attribute.String("wmstats.source", wmgr.Label),
+		attribute.Int("wmstats.filters", len(filters)))
+	defer span.End()
 
 	//     data := readData(fname)
 	// create our stats maps
@@ -61,6 +130,7 @@ func wmstats(wmgr *WMStatsManager, filters WMStatsFilters, verbose int) *WMStats
 	siteMap := make(WorkflowMap)
 	cmsswMap := make(WorkflowMap)
 	agentMap := make(WorkflowMap)
+	prepidMap := make(WorkflowMap)
 
 	// main loop
 	for _, info := range data {
@@ -90,14 +160,20 @@ func wmstats(wmgr *WMStatsManager, filters WMStatsFilters, verbose int) *WMStats
 			}
 			updateMap(campaignMap, campaign, wObj)
 			updateMap(cmsswMap, cmssw, wObj)
+			if rdict.PrepID != "" {
+				updateMap(prepidMap, rdict.PrepID, wObj)
+			}
 
 			// collect workflow information
 			wInfo := WorkflowInfo{
 				Name:     rdict.RequestName,
 				Campaign: rdict.Campaign,
+				PrepID:   rdict.PrepID,
 				Type:     rdict.RequestType,
 				Priority: rdict.RequestPriority,
 				Sites:    rdict.Sites,
+				Releases: []string{cmssw},
+				State:    rdict.RequestStatus,
 			}
 			// keey workflow info regardless of AgentJobInfoMap which may be missing
 			wmap[workflow] = wInfo
@@ -136,6 +212,15 @@ func wmstats(wmgr *WMStatsManager, filters WMStatsFilters, verbose int) *WMStats
 				// update site info
 				for site, status := range ainfo.Sites {
 					updateMap(siteMap, site, wObj)
+					if winfo, ok := wmap[workflow]; ok {
+						if winfo.SiteJobs == nil {
+							winfo.SiteJobs = make(map[string]Status)
+						}
+						sjobs := winfo.SiteJobs[site]
+						sjobs.Update(status)
+						winfo.SiteJobs[site] = sjobs
+						wmap[workflow] = winfo
+					}
 					coolOff := status.CoolOff.Sum()
 					pending := status.Submitted.Pending
 					running := status.Submitted.Running
@@ -188,6 +273,7 @@ func wmstats(wmgr *WMStatsManager, filters WMStatsFilters, verbose int) *WMStats
 		if totJobs != 0 {
 			stats.FailureRate = 100 * float64(stats.FailJobs) / float64(totJobs)
 		}
+		smap[site] = stats
 		if verbose > 1 {
 			fmt.Printf("%+v\n", stats)
 		}
@@ -246,6 +332,8 @@ func wmstats(wmgr *WMStatsManager, filters WMStatsFilters, verbose int) *WMStats
 			stats.FailureRate = cs.FailureRate()
 			stats.Requests = cs.Requests
 			stats.CoolOff = cs.Status.CoolOff.Sum()
+			stats.Running = cs.Status.Submitted.Running
+			stats.Pending = cs.Status.Submitted.Pending
 			cmap[campaign] = stats
 		}
 		if verbose > 1 {
@@ -265,6 +353,8 @@ func wmstats(wmgr *WMStatsManager, filters WMStatsFilters, verbose int) *WMStats
 			JobProgress: 0, // TODO
 			Requests:    data.Requests,
 			CoolOff:     data.Status.CoolOff.Sum(),
+			Running:     data.Status.Submitted.Running,
+			Pending:     data.Status.Submitted.Pending,
 		}
 		amap[agent] = astats
 	}
@@ -284,10 +374,13 @@ func wmstats(wmgr *WMStatsManager, filters WMStatsFilters, verbose int) *WMStats
 			FailureRate:   float64(data.Status.Failure.Sum()),
 			Requests:      data.Requests,
 			CoolOff:       data.Status.CoolOff.Sum(),
+			Running:       data.Status.Submitted.Running,
+			Pending:       data.Status.Submitted.Pending,
 		}
 		rmap[cmssw] = rstats
 	}
-	fmt.Println("### Total number of workflows", len(wmap), "in", time.Since(time0))
+	log.Println("### Total number of workflows", len(wmap), "in", time.Since(time0))
+	span.SetAttributes(attribute.Int("wmstats.workflows", len(wmap)))
 	stats := WMStatsInfo{
 		CampaignStatsMap:  cmap,
 		SiteStatsMap:      smap,
@@ -297,8 +390,11 @@ func wmstats(wmgr *WMStatsManager, filters WMStatsFilters, verbose int) *WMStats
 		SiteWorkflows:     siteMap,
 		CMSSWWorkflows:    cmsswMap,
 		AgentWorkflows:    agentMap,
+		PrepIDWorkflows:   prepidMap,
+		Workflows:         wmap,
+		SearchIndex:       NewSearchIndex(wmap),
 	}
-	return &stats
+	return &stats, nil
 }
 
 func updateReleaseSummary(cmssw string, cmsswSummary map[string]CMSSWSummary, status Status) {